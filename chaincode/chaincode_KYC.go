@@ -15,19 +15,19 @@ specific language governing permissions and limitations
 under the License.
 */
 
-
 package main
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
 	//"github.com/derekparker/delve/pkg/dwarf/line"
 )
 
@@ -35,10 +35,11 @@ import (
 type SimpleChaincode struct {
 }
 
-var customerIndexStr = "_customerindex" //name for the key/value that will store a list of all known customers
-var brokerIndexStr = "_brokerindex"     //name for the key/value that will store a list of all known customers
+var customerIndexStr = "customer~guaranteeid" //composite key object type of the entries listing every known customer
+var brokerIndexStr = "broker~brokeno"         //composite key object type of the entries listing every known broker
+var adminIndexStr = "_adminindex"             //name for the key/value that will store a list of the MSP ids admins come from
 
-// key of customer
+// key of customer, in the private customerCollection under its guarantee id
 var customerKey = "cus_"
 
 // BrokerKey key of broker
@@ -47,41 +48,91 @@ var BrokerKey = "bro_"
 // GuaranteeIDKey key of guarantee id
 var GuaranteeIDKey = "gua_"
 
-// CusGuaIDKey key of something
+// CusGuaIDKey key of the private link from a card id to its guarantee id, under the card id's privateKey
 var CusGuaIDKey = "cgi_"
 
-//Customer is Customer
+// ErasedKey key of the tombstone left behind by an erased customer
+var ErasedKey = "era_"
+
+// Customer is Customer
 type Customer struct {
-	Name       string `json:"name"` //the fieldtags are needed to keep case from bouncing around
-	CardID     string `json:"cardid"`
-	TelNo      string `json:"telno"`
-	Age        int    `json:"age"`
-	Occupation string `json:"occupation"`
-	Address    string `json:"address"`
-	Creator    string `json:"creator"`
+	Name         string  `json:"name"` //the fieldtags are needed to keep case from bouncing around
+	CardID       string  `json:"cardid"`
+	TelNo        string  `json:"telno"`
+	DOB          string  `json:"dob"`
+	Age          int     `json:"age,omitempty"` //worked out from DOB on every read, only records from before DOB store it
+	Nationality  string  `json:"nationality"`
+	Occupation   string  `json:"occupation"`
+	Address      Address `json:"address"`
+	IDType       string  `json:"idtype"` //what CardID is the number of, idTypeNationalID or idTypePassport
+	IDIssueDate  string  `json:"idissuedate"`
+	IDExpiryDate string  `json:"idexpirydate"`
+	Creator      string  `json:"creator"`      //certificate subject of the identity that holds the record
+	CreatorMSPID string  `json:"creatormspid"` //and its MSP
+	// PreviousCreators is the ownership trail, oldest first
+	PreviousCreators []CreatorChange `json:"previouscreators"`
+}
+
+// CreatorChange records a creator handing a customer record over to someone else
+type CreatorChange struct {
+	Creator      string `json:"creator"`
+	CreatorMSPID string `json:"creatormspid"`
+	ChangedBy    string `json:"changedby"` //certificate subject of whoever made the change
+	ChangedAt    string `json:"changedat"`
 }
 
-//GuaranteeID generate from Customer
+// GuaranteeID generate from Customer, the brokers it is shared with are Consent records. The public record carries
+// CustomerHash, the copy in customerCollection carries CustomerID and CustomerMSPID
 type GuaranteeID struct {
-	GuaranteeID  string `json:"guaranteeid"`
-	CustomerID   string `json:"customerid"`
-	AllowBroke   []int  `json:"allowbroke"`
-	PendingBroke []int  `json:"pendingbroke"`
+	GuaranteeID   string `json:"guaranteeid"`
+	CustomerID    string `json:"customerid,omitempty"`
+	CustomerMSPID string `json:"customermspid,omitempty"` //the MSP whose CA enrolls the customer, see assertCustomer
+	CustomerHash  string `json:"customerhash,omitempty"`
 }
 
-// type CusGuaID struct {
-// 	CardID      string      `json:"cardid"`
-// 	Customer    Customer    `json:"customer"`
-// 	GuaranteeID GuaranteeID `json:"guaranteeid"`
-// }
+// Erasure is the tombstone written in place of an erased customer, it holds no personal data
+type Erasure struct {
+	GuaranteeID string `json:"guaranteeid"`
+	Erased      bool   `json:"erased"`
+	ErasedAt    string `json:"erasedat"`
+	TxID        string `json:"txid"`
+}
 
-// Broker Contain Name and Number and AllowCustomer
+// Broker Contain Name and Number, the customers it may see are Consent records
 type Broker struct {
-	Name            string   `json:"name"`
-	BrokerNo        int      `json:"brokerno"`
-	AllowCustomer   []string `json:"allowcustomer"`
-	PendingCustomer []string `json:"pendingcustomer"`
-	rejectCustomer	[]string `json:"rejectcustomer"`
+	Name     string `json:"name"`
+	BrokerNo int    `json:"brokerno"`
+	Subject  string `json:"subject"` //certificate subject of the identity that registered the broker
+	MSPID    string `json:"mspid"`   //and its MSP, together they are the only identity that can act as the broker
+}
+
+// guaranteeDetail is what readcustomergid returns, a guarantee id and every consent given against it
+type guaranteeDetail struct {
+	GuaranteeID
+	Consents []Consent `json:"consents"`
+}
+
+// customerConsents is what readcustomerconsents returns, a customer's guarantee id and its consents sorted by status
+type customerConsents struct {
+	CardID      string          `json:"cardid"`
+	GuaranteeID string          `json:"guaranteeid"`
+	Pending     []brokerConsent `json:"pending"`
+	Allowed     []brokerConsent `json:"allowed"`
+	Rejected    []brokerConsent `json:"rejected"`
+	Expired     []brokerConsent `json:"expired"`
+	Revoked     []brokerConsent `json:"revoked"`
+}
+
+// brokerConsent is a consent with the name of the broker it is for
+type brokerConsent struct {
+	Name string `json:"name"`
+	Consent
+}
+
+// brokerDetail is what readbroker returns, a broker and every consent it holds or asked for
+type brokerDetail struct {
+	Broker
+	Consents []Consent `json:"consents"`
 }
 
 // Main
@@ -92,20 +143,43 @@ func main() {
 	}
 }
 
-// Init - reset all the things
-func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+// Init - called when the chaincode is instantiated or upgraded
+// ============================================================================================================================
+func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	return initFunction.run(t, stub, "init", args)
+}
+
+// Invoke - Our entry point for Invocations and Queries
+// ============================================================================================================================
+func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	fmt.Println("invoke is running " + function)
+	return t.call(stub, function, args)
+}
+
+// ============================================================================================================================
+// respond - turn a function's payload and error into the peer response
+// ============================================================================================================================
+func respond(payload []byte, err error) pb.Response {
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(payload)
+}
+
+// ============================================================================================================================
+// Reset - reset all the things
+// ============================================================================================================================
+func (t *SimpleChaincode) reset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var Aval int
 	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
-	}
+	//   0         1...
+	// "value", "adminmspid"...
 
 	// Initialize the chaincode
-	Aval, err = strconv.Atoi(args[0])
-	if err != nil {
-		return nil, errors.New("Expecting integer value for asset holding")
-	}
+	Aval, _ = strconv.Atoi(args[0])
 
 	// Write the state to the ledger
 	err = stub.PutState("kyc", []byte(strconv.Itoa(Aval))) //making a test var "kyc", I find it handy to read/write to it right away to test the network
@@ -113,14 +187,15 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 		return nil, err
 	}
 
-	var empty []string
-	jsonAsBytes, _ := json.Marshal(empty) //marshal an emtpy array of strings to clear the index
-	err = stub.PutState(customerIndexStr, jsonAsBytes)
+	admins := args[1:] //any further arguments are the MSPs whose admins may act on every customer
+	jsonAsBytes, _ := json.Marshal(admins)
+	err = stub.PutState(adminIndexStr, jsonAsBytes)
 	if err != nil {
 		return nil, err
 	}
 
-	err = stub.PutState(brokerIndexStr, jsonAsBytes)
+	//the secret card ids and names are keyed with comes in the transient map, the first time only
+	err = putCardIDSecret(stub)
 	if err != nil {
 		return nil, err
 	}
@@ -128,529 +203,831 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	return nil, nil
 }
 
-// Run - Our entry point for Invocations - [LEGACY] obc-peer 4/25/2016
 // ============================================================================================================================
-func (t *SimpleChaincode) Run(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("run is running " + function)
-	return t.Invoke(stub, function, args)
-}
-
-// Invoke - Our entry point for Invocations
+// Read - read a variable from chaincode state
 // ============================================================================================================================
-func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("invoke is running " + function)
+func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var name string
+	var err error
 
-	// Handle different functions
-	if function == "init" { //initialize the chaincode state, used as reset
-		return t.Init(stub, "init", args)
-	} else if function == "write" { //writes a value to the chaincode state
-		return t.Write(stub, args)
-	} else if function == "newcustomer" { //create a new customer
-		return t.newcustomer(stub, args)
-	} else if function == "newbroke" {
-		return t.newbroke(stub, args)
-	} else if function == "requestPermission" {
-		return t.requestPermission(stub, args)
-	} else if function == "customerallow" {
-		return t.customerallow(stub, args)
-	} else if function == "cancelAllow"{
-		return  t.cancelAllow(stub,args)
-	} else if function == "rejectBroker" {
-		return t.rejectBroker(stub, args)
+	name = args[0]
+	valAsbytes, err := stub.GetState(name) //get the var from chaincode state
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get state for "+name)
+	}
+	if len(valAsbytes) == 0 {
+		return nil, notFound("name", "Nothing is stored under "+name)
 	}
-	fmt.Println("invoke did not find func: " + function) //error
 
-	return nil, errors.New("Received unknown function invocation")
+	return valAsbytes, nil //send it onward
 }
 
-// Query - Our entry point for Queries
 // ============================================================================================================================
-func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("query is running " + function)
+// Read - read a customer by cardid, only the customer, the holder of its record or an admin can
+// ============================================================================================================================
+func (t *SimpleChaincode) readcustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var cardid string
+	var err error
 
-	// Handle different functions
-	if function == "read" { //read a variable
-		return t.read(stub, args)
+	cardid = args[0]
+	link, err := getCusGuaID(stub, cardid)
+	if err != nil {
+		return nil, err
 	}
-	if function == "readcustomer" { //read a variable
-		return t.readcustomer(stub, args)
+	gua, err := getGuarantee(stub, link.GuaranteeID)
+	if err != nil {
+		return nil, err
 	}
-	if function == "readcustomergid" {
-		return t.readcustomergid(stub, args)
+	res, err := getCustomer(stub, link.GuaranteeID) //get the var from the private collection
+	if err != nil {
+		return nil, err
 	}
-	if function == "readbroker" { //read a variable
-		return t.readbroker(stub, args)
+	err = assertCustodian(stub, gua, res)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("query did not find func: " + function) //error
 
-	return nil, errors.New("Received unknown function query")
+	jsonAsBytes, _ := json.Marshal(withAge(res, now))
+	return jsonAsBytes, nil //send it onward
 }
 
 // ============================================================================================================================
-// Read - read a variable from chaincode state
+// Read - every customer with a name the caller may read, through the private name index. That is the customer
+// themselves, the holder of the record or an admin, anyone else gets an empty list
 // ============================================================================================================================
-func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var name, jsonResp string
-	var err error
+func (t *SimpleChaincode) readcustomername(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//    0
+	// "name"
+	name := args[0]
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	nameKey, err := privateKey(stub, name)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(customerCollection, customerNameIndex, []string{nameKey})
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get customers by name")
+	}
+	defer resultsIterator.Close()
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+	customers := []Customer{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, ledgerError(err, "Failed to iterate customers by name")
+		}
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, ledgerError(err, "Failed to split name index key")
+		}
+		gua, err := getGuarantee(stub, keyParts[1])
+		if kerr, ok := err.(*kycError); ok && kerr.Code == codeNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res, err := getCustomer(stub, keyParts[1])
+		if kerr, ok := err.(*kycError); ok && kerr.Code == codeNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = assertCustodian(stub, gua, res)
+		if kerr, ok := err.(*kycError); ok && kerr.Code == codePermissionDenied {
+			continue //not one of the caller's, leave it out
+		}
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, withAge(res, now))
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetState(name) //get the var from chaincode state
+	jsonAsBytes, _ := json.Marshal(customers)
+	return jsonAsBytes, nil //send it onward
+}
+
+// ============================================================================================================================
+// Read - a customer looks up their own guarantee id by cardid, with the brokers waiting on, holding or refused consent
+// ============================================================================================================================
+func (t *SimpleChaincode) readcustomerconsents(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//    0
+	// "cardid"
+	cardid := args[0]
+	link, err := getCusGuaID(stub, cardid)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
+	}
+	gua, err := getGuarantee(stub, link.GuaranteeID)
+	if err != nil {
+		return nil, err
+	}
+	err = assertCustomer(stub, gua)
+	if err != nil {
+		return nil, err
+	}
+	consents, err := consentsByGuarantee(stub, link.GuaranteeID)
+	if err != nil {
+		return nil, err
 	}
 
-	return valAsbytes, nil //send it onward
+	detail := customerConsents{}
+	detail.CardID = link.CardID
+	detail.GuaranteeID = link.GuaranteeID
+	detail.Pending = []brokerConsent{}
+	detail.Allowed = []brokerConsent{}
+	detail.Rejected = []brokerConsent{}
+	detail.Expired = []brokerConsent{}
+	detail.Revoked = []brokerConsent{}
+	for _, consent := range consents {
+		brokerAsBytes, err := stub.GetState(BrokerKey + strconv.Itoa(consent.BrokerNo))
+		if err != nil {
+			return nil, ledgerError(err, "Failed to get state for broker "+strconv.Itoa(consent.BrokerNo))
+		}
+		broker := Broker{}
+		json.Unmarshal(brokerAsBytes, &broker)
+
+		entry := brokerConsent{Name: broker.Name, Consent: consent}
+		switch consent.Status {
+		case statusPending:
+			detail.Pending = append(detail.Pending, entry)
+		case statusAllowed:
+			detail.Allowed = append(detail.Allowed, entry)
+		case statusRejected:
+			detail.Rejected = append(detail.Rejected, entry)
+		case statusExpired:
+			detail.Expired = append(detail.Expired, entry)
+		case statusRevoked:
+			detail.Revoked = append(detail.Revoked, entry)
+		}
+	}
+
+	jsonAsBytes, _ := json.Marshal(detail)
+	return jsonAsBytes, nil //send it onward
 }
 
 // ============================================================================================================================
-// Read - read a variable from chaincode state by cardid
+// Read - read a variable from chaincode state by guaranteeid
 // ============================================================================================================================
-func (t *SimpleChaincode) readcustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var cardid, jsonResp string
+func (t *SimpleChaincode) readcustomergid(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var gid string
 	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+	gid = args[0]
+	valAsbytes, err := stub.GetState(GuaranteeIDKey + gid) //get the var from chaincode state
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get state for "+gid)
 	}
 
-	cardid = args[0]
-	valAsbytes, err := stub.GetState(customerKey + cardid) //get the var from chaincode state
+	if len(valAsbytes) == 0 { //an erased customer leaves a tombstone behind instead
+		tombstoneAsBytes, err := stub.GetState(ErasedKey + gid)
+		if err != nil {
+			return nil, ledgerError(err, "Failed to get state for "+gid)
+		}
+		if len(tombstoneAsBytes) == 0 {
+			return nil, notFound("guaranteeid", "This guarantee id does not exist")
+		}
+		return tombstoneAsBytes, nil
+	}
+
+	detail := guaranteeDetail{}
+	json.Unmarshal(valAsbytes, &detail.GuaranteeID)
+	detail.Consents, err = consentsByGuarantee(stub, gid)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + cardid + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
 	}
 
-	return valAsbytes, nil //send it onward
+	jsonAsBytes, _ := json.Marshal(detail)
+	return jsonAsBytes, nil //send it onward
 }
 
 // ============================================================================================================================
-// Read - read a variable from chaincode state by guaranteeid
+// Read - a broker reads a customer by guaranteeid, only while it is allowed and only the fields it was granted
 // ============================================================================================================================
-func (t *SimpleChaincode) readcustomergid(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var gid, jsonResp string
-	var err error
+func (t *SimpleChaincode) readcustomerbroker(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//      0            1
+	// "guaranteeid", "brokeno"
+	gid := args[0]
+	brokeNo, _ := strconv.Atoi(args[1])
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+	gua, broker, err := checkConsentParties(stub, gid, brokeNo)
+	if err != nil {
+		return nil, err
 	}
-
-	gid = args[0]
-	valAsbytes, err := stub.GetState(GuaranteeIDKey + gid) //get the var from chaincode state
+	err = assertBroker(stub, broker)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + gid + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
+	}
+	consent, err := getConsent(stub, gid, brokeNo)
+	if err != nil {
+		return nil, err
+	}
+	if consent.Status != statusAllowed {
+		return nil, permissionDenied("brokeno", "This broker is not allowed to read this customer")
 	}
 
-	gua := GuaranteeID{}
-	json.Unmarshal(valAsbytes, &gua)
+	customer, err := getCustomer(stub, gua.GuaranteeID)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	customer = withAge(customer, now)
 
-	return valAsbytes, nil //send it onward
+	scope := consent.Scope
+	if len(scope) == 0 {
+		scope = scopeFields //granted before scopes, the grant covered everything
+	}
+	return scopedCustomer(customer, scope), nil //send it onward
 }
 
 // ============================================================================================================================
 // Read - read a variable from chaincode state by brokeno
 // ============================================================================================================================
 func (t *SimpleChaincode) readbroker(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var brokeno, jsonResp string
+	var brokeno string
 	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+	//the key newbroke wrote it under
+	brokeNo, _ := strconv.Atoi(args[0])
+	brokeno = strconv.Itoa(brokeNo)
+	valAsbytes, err := stub.GetState(BrokerKey + brokeno) //get the var from chaincode state
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get state for "+brokeno)
+	}
+	if len(valAsbytes) == 0 {
+		return nil, notFound("brokeno", "This broker does not exist")
 	}
 
-	brokeno = args[0]
-	valAsbytes, err := stub.GetState(BrokerKey + brokeno) //get the var from chaincode state
+	detail := brokerDetail{}
+	json.Unmarshal(valAsbytes, &detail.Broker)
+	err = assertBroker(stub, detail.Broker) //a broker's consents are its own business
+	if err != nil {
+		return nil, err
+	}
+	detail.Consents, err = consentsByBroker(stub, detail.BrokerNo)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + brokeno + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
 	}
 
-	return valAsbytes, nil //send it onward
+	jsonAsBytes, _ := json.Marshal(detail)
+	return jsonAsBytes, nil //send it onward
 }
 
+// ============================================================================================================================
+// Request Permission - a broker asks a customer for access, for itself only, and gets back the request record
+// ============================================================================================================================
 func (t *SimpleChaincode) requestPermission(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	// var brokerno, gid string
-	// var err error
+	//      0            1          2          3          4
+	// "guaranteeid", "brokeno", "fields", "purpose", "message"
+	gid := args[0]
+	brokeNo, _ := strconv.Atoi(args[1])
+	requested := parseScope(args[2]) //omitted asks for every field
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting guarantee id and brokeno")
+	_, broker, err := checkConsentParties(stub, gid, brokeNo)
+	if err != nil {
+		return nil, err
 	}
-
-	gid := args[0]
-	//brokeno := args[1]
-	brokeNoAsString := args[1]
-	brokeNo, err := strconv.Atoi(brokeNoAsString)
-	brokerAsBytes, err := stub.GetState(BrokerKey + brokeNoAsString)
+	err = assertBroker(stub, broker) //brokers ask for themselves only
+	if err != nil {
+		return nil, err
+	}
+	consent, err := getConsent(stub, gid, brokeNo)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for broker " + brokeNoAsString + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
+	}
+	if consent.Status == statusAllowed {
+		return nil, alreadyExists("brokeno", "Already allowed "+args[1])
+	}
+	if consent.Status == statusPending && len(consent.RequestID) > 0 {
+		return nil, alreadyExists("brokeno", "This broker already has request "+consent.RequestID+" pending")
 	}
-	broker := Broker{}
-	json.Unmarshal(brokerAsBytes, &broker)
 
-	gidAsbytes, err := stub.GetState(GuaranteeIDKey + gid)
+	createdAt, err := txTimestamp(stub)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + gid + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
+	}
+	req := AccessRequest{}
+	req.RequestID = stub.GetTxID()
+	req.GuaranteeID = gid
+	req.BrokerNo = brokeNo
+	req.Purpose = args[3]
+	req.Message = args[4]
+	req.Fields = requested
+	req.CreatedAt = createdAt
+	req.Status = statusPending
+	err = putRequest(stub, req)
+	if err != nil {
+		return nil, err
 	}
-	gua := GuaranteeID{}
-	json.Unmarshal(gidAsbytes, &gua)
 
-	already := false
-	fmt.Printf("GID %s\n", gidAsbytes)
-	fmt.Printf("brokeno %d\n", brokeNo)
-	for _, s := range gua.AllowBroke {
-		//set[s] = struct{}{}
-		if s == brokeNo {
-			already = true
+	//the request the consent was last moved by is over. An expiry is only worked out on read, while the consent still
+	//points at it, so write down how it ended before the consent moves on
+	if len(consent.RequestID) > 0 {
+		old, err := getRequest(stub, consent.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		old.Status = consent.Status
+		err = putRequest(stub, old)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	fmt.Println("already" + strconv.FormatBool(already))
-
-	if already {
-		jsonResp := "{\"Error\":\"Already Allowed " + brokeNoAsString + "\"}"
-		return nil, errors.New(jsonResp)
+	consent.Requested = requested
+	consent.RequestID = req.RequestID
+	err = setConsentStatus(stub, consent, statusPending)
+	if err != nil {
+		return nil, err
 	}
 
-	gua.PendingBroke = append(gua.PendingBroke, brokeNo)
-	broker.PendingCustomer = append(broker.PendingCustomer, gua.GuaranteeID)
+	fmt.Println("- end request permission")
+	jsonAsBytes, _ := json.Marshal(req)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Set User Permission on Customer - only the customer can allow a broker's request, optionally for a number of days
+// ============================================================================================================================
+func (t *SimpleChaincode) customerallow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0          1        2
+	// "requestid", "days", "fields"
+	days, _ := strconv.Atoi(args[1]) //omitted is 0, the grant does not lapse
 
-	fmt.Println("gua.AllowBroke")
-	fmt.Println(gua.AllowBroke)
+	req, gua, consent, err := pendingRequest(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = assertCustomer(stub, gua)
+	if err != nil {
+		return nil, err
+	}
 
-	jsonAsBytes, err := json.Marshal(gua)
-	fmt.Printf("GID %s\n", jsonAsBytes)
-	err = stub.PutState(GuaranteeIDKey+gid, jsonAsBytes) //write the variable into the chaincode state
+	//the customer grants some or all of what was asked for, never more
+	consent.Requested = req.Fields
+	consent.Scope = req.Fields
+	if len(args[2]) > 0 {
+		consent.Scope = parseScope(args[2])
+		for _, field := range consent.Scope {
+			if !inScope(req.Fields, field) {
+				return nil, invalidArgument("fields", "The broker did not ask for "+field)
+			}
+		}
+	}
+	consent.ExpiresAt, err = consentExpiry(stub, days)
 	if err != nil {
 		return nil, err
 	}
 
-	jsonBrokerAsBytes, err := json.Marshal(broker)
-	fmt.Printf("Broke %s\n", jsonBrokerAsBytes)
-	err = stub.PutState(BrokerKey+brokeNoAsString, jsonBrokerAsBytes) //write the variable into the chaincode state
+	consent.RequestID = req.RequestID
+	err = setConsentStatus(stub, consent, statusAllowed)
+	if err != nil {
+		return nil, err
+	}
+	req.Status = statusAllowed
+	err = putRequest(stub, req)
 	if err != nil {
 		return nil, err
 	}
 
+	fmt.Println("- end set allow permission")
 	return nil, nil
 }
 
 // ============================================================================================================================
-// Set User Permission on Customer
+// renew Allow broker - extend an allowed or expired grant by a number of days from now, only the customer can do it
 // ============================================================================================================================
-func (t *SimpleChaincode) customerallow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var err error
-	//gid, brokeno
+func (t *SimpleChaincode) renewAllow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//      0            1        2
+	// "guaranteeid", "brokeno", "days"
+	gid := args[0]
+	brokeNo, _ := strconv.Atoi(args[1])
+	days, _ := strconv.Atoi(args[2])
 
-	if len(args) < 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	gua, _, err := checkConsentParties(stub, gid, brokeNo)
+	if err != nil {
+		return nil, err
+	}
+	err = assertCustomer(stub, gua)
+	if err != nil {
+		return nil, err
+	}
+	consent, err := getConsent(stub, gid, brokeNo)
+	if err != nil {
+		return nil, err
+	}
+	if consent.Status != statusAllowed && consent.Status != statusExpired {
+		return nil, notFound("brokeno", "This broker has never been allowed")
 	}
+	consent.ExpiresAt, err = consentExpiry(stub, days)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setConsentStatus(stub, consent, statusAllowed)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("- end renew allow")
+	return nil, nil
+}
 
+// ==================================================================================================================
+// cancel Allow broker - revoke an allowed broker's consent, only the customer can do it
+// ==================================================================================================================
+func (t *SimpleChaincode) cancelAllow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//      0            1
+	// "guaranteeid", "brokeno"
 	gid := args[0]
-	brokeNoAsString := args[1]
-	brokeNo, err := strconv.Atoi(brokeNoAsString)
+	brokeNo, _ := strconv.Atoi(args[1])
 
-	gidAsBytes, err := stub.GetState(GuaranteeIDKey + gid)
+	gua, _, err := checkConsentParties(stub, gid, brokeNo)
 	if err != nil {
-		return nil, errors.New("Failed to get thing")
+		return nil, err
 	}
-	gua := GuaranteeID{}
-	json.Unmarshal(gidAsBytes, &gua)
-
-	brokeAsBytes, err := stub.GetState(BrokerKey + brokeNoAsString)
+	err = assertCustomer(stub, gua)
 	if err != nil {
-		return nil, errors.New("Failed to get thing")
+		return nil, err
+	}
+	consent, err := getConsent(stub, gid, brokeNo)
+	if err != nil {
+		return nil, err
+	}
+	if consent.Status != statusAllowed {
+		return nil, notFound("brokeno", "This broker is not allowed")
+	}
+	consent.RevokedBy, _, err = submitter(stub)
+	if err != nil {
+		return nil, err
+	}
+	consent.RevokedAt, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
 	}
-	broke := Broker{}
-	json.Unmarshal(brokeAsBytes, &broke)
 
-	for i := len(gua.PendingBroke) - 1; i >= 0; i-- {
-		if gua.PendingBroke[i] == brokeNo {
-			gua.PendingBroke = append(gua.PendingBroke[:i], gua.PendingBroke[i+1:]...)
-			break
-		}
+	//the consent event this sets is the broker's notice, it has to ask again to get access back
+	err = setConsentStatus(stub, consent, statusRevoked)
+	if err != nil {
+		return nil, err
 	}
-	for i := len(broke.PendingCustomer) - 1; i >= 0; i-- {
-		if broke.PendingCustomer[i] == gid {
-			broke.PendingCustomer = append(broke.PendingCustomer[:i], broke.PendingCustomer[i+1:]...)
-			break
+	if len(consent.RequestID) > 0 { //and the request the access was granted on is over
+		req, err := getRequest(stub, consent.RequestID)
+		if err != nil {
+			return nil, err
 		}
-	}
-	already := false
-	for _, s := range gua.AllowBroke {
-		if s == brokeNo {
-			already = true
+		req.Status = statusRevoked
+		err = putRequest(stub, req)
+		if err != nil {
+			return nil, err
 		}
 	}
-	if !already {
-		gua.AllowBroke = append(gua.AllowBroke, brokeNo)
-		broke.AllowCustomer = append(broke.AllowCustomer, gid)
-	}
 
-	//write state
+	fmt.Println("- end cancel allow")
+	return nil, nil
+}
 
-	jsonAsBytes, _ := json.Marshal(broke)
-	err = stub.PutState(BrokerKey+brokeNoAsString, jsonAsBytes) //rewrite the customer with id as key
+// =================================================================================================================
+// Reject Broker number - turn down a broker's pending request, optionally saying why, only the customer can do it
+// =================================================================================================================
+func (t *SimpleChaincode) rejectBroker(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0           1
+	// "requestid", "reason"
+	reason := args[1]
+
+	req, gua, consent, err := pendingRequest(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = assertCustomer(stub, gua)
 	if err != nil {
 		return nil, err
 	}
+	//kept on the consent record, so both readcustomergid and readbroker show it
+	consent.RejectedAt, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	consent.Reason = reason
 
-	jsonGuaAsBytes, _ := json.Marshal(gua)
-	err = stub.PutState(GuaranteeIDKey+gid, jsonGuaAsBytes) //rewrite the customer with id as key
+	consent.RequestID = req.RequestID
+	err = setConsentStatus(stub, consent, statusRejected)
+	if err != nil {
+		return nil, err
+	}
+	req.Status = statusRejected
+	err = putRequest(stub, req)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("- end set allow permission")
+	fmt.Println("- end reject broker")
 	return nil, nil
 }
-// ==================================================================================================================
-	// cancel Allow broker
-// ==================================================================================================================
-func (t *SimpleChaincode) cancelAllow(stub shim.ChaincodeStubInterface, args []string) ([]byte , error){
+
+// ============================================================================================================================
+// Init Customer - create a new customer, store into chaincode state
+// ============================================================================================================================
+func (t *SimpleChaincode) newcustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
-	if len(args) == 0 {
-		return nil,errors.New("Argument is NULL")
-	}else if len(args[0]) == 0 {
-		return nil, errors.New("Argument is NULL")
-	}else if len(args[1]) == 0 {
-		return nil, errors.New("Argument is NULL")
+
+	//no arguments, the customer comes in the transient map, see customerInput, and the submitter becomes its creator
+	fmt.Println("- start init customer")
+	input, err := transientCustomer(stub)
+	if err != nil {
+		return nil, err
 	}
-	gid := args[0]
-	brkstring := args[1]
-	brkNO,err :=  strconv.Atoi(brkstring)
-	resGID := GuaranteeID{}
-	customerASByte,err := stub.GetState(customerKey + gid)
-	if err != nil {
-		return nil, errors.New("Can't gate state")
-	}
-	json.Unmarshal(customerASByte,&resGID)
-	for i := len(resGID.AllowBroke) -1 ;i < 0 ;i-- {
-		if resGID.AllowBroke[i] == brkNO {
-			resGID.AllowBroke = append(resGID.AllowBroke[:i],resGID.AllowBroke[i+1:]...) //remove broker number from allowbroker of  Guarantee structure
-			resGID.PendingBroke =append(resGID.PendingBroke,brkNO) //add broker number to pendingBroker of Guarantee structure
-		}
+	name := input.Name
+	telno := strings.ToLower(input.TelNo)
+	occupation := strings.ToLower(input.Occupation)
+	cardid := input.CardID
+	creator, creatorMSPID, err := submitter(stub)
+	if err != nil {
+		return nil, err
 	}
-	brokerAsBye,err := stub.GetState(string(brkNO))
-	resBrk := Broker{}
-	json.Unmarshal(brokerAsBye,&resBrk)
-	for i := len(resBrk.AllowCustomer) -1;i<0;i-- {
-		if resBrk.AllowCustomer[i] == gid {
-			resBrk.AllowCustomer = append(resBrk.AllowCustomer[:i],resBrk.AllowCustomer[i+1:]...) //remove guarantee ID from allowCustomer of Broker structure
-			resBrk.PendingCustomer =append(resBrk.PendingCustomer,gid) //add guarantee ID form pendingCustomer of Broker struct
 
-		}
+	fmt.Println("- pass2 init customer")
+	//check if customer already exists, the card id is unique, names can be shared
+	_, err = getCusGuaID(stub, cardid)
+	if err == nil {
+		//no card id in the message, it is personal data and the peer log is not private
+		fmt.Println("This customer already exists")
+		return nil, alreadyExists("cardid", "A customer with this card id already exists") //all stop a customer with this card id exists
+	}
+	if kerr, ok := err.(*kycError); !ok || kerr.Code != codeNotFound || kerr.Arg != "cardid" {
+		return nil, err
 	}
-	// write Guarantee ID
-	jsonGIDAsByte, _ := json.Marshal(resGID)
-	err = stub.PutState(GuaranteeIDKey + gid,jsonGIDAsByte)
+	res := Customer{}
+
+	res.Name = name
+	res.TelNo = telno
+	res.Occupation = occupation
+	res.CardID = cardid
+	res.Creator = creator
+	res.CreatorMSPID = creatorMSPID
+	input.profile(&res)
+	salt, err := transientSalt(stub)
 	if err != nil {
-		return nil, errors.New("Can't put state Guarantee ID")
+		return nil, err
 	}
-	jsonBrkAsByte, _ := json.Marshal(resBrk)
-	err = stub.PutState(string(brkNO),jsonBrkAsByte)
+	gid, err := newGuaranteeID(stub)
 	if err != nil {
-		return nil, errors.New("Can't put state Broker Number")
+		return nil, err
 	}
-	return nil,nil
-}
-// =================================================================================================================
-	//Reject Broker nubmer
-// =================================================================================================================
-func (t *SimpleChaincode) rejectBroker(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
-	var err error
 
-	if len(args) == 0 {
-		return nil, errors.New("Argument is NULL")
-	}else if len(args[0]) == 0{
-		return nil,errors.New("Argument is NULL")
-	}else if len(args) == 0 {
-		return nil, errors.New("Argument is NULL")
+	guaranteeID := GuaranteeID{}
+	guaranteeID.GuaranteeID = gid
+	guaranteeID.CustomerID = res.CardID
+	guaranteeID.CustomerMSPID = creatorMSPID //customers are enrolled by the org that takes them on
+
+	//the guarantee id to card id link and its reverse stay private, the public record gets the hash in putCustomer
+	str, err := json.Marshal(guaranteeID)
+	err = stub.PutPrivateData(customerCollection, GuaranteeIDKey+guaranteeID.GuaranteeID, str)
+	if err != nil {
+		return nil, err
 	}
-	gid := args[0]
-	brkstring := args[1]
-	brkNO,err := strconv.Atoi(brkstring)
-	GIDAsByte,err := stub.GetState(GuaranteeIDKey + gid)
-	resGID := GuaranteeID{}
-	json.Unmarshal(GIDAsByte,&resGID)
-	for i := len(resGID.PendingBroke) -1 ;i < 0 ;i-- {
-		if resGID.PendingBroke[i] == brkNO {
-			resGID.PendingBroke = append(resGID.PendingBroke[:i], resGID.PendingBroke[i+1:]...)  //remove Broker from peddind of struct Guarantee
-			fmt.Printf("after append in pending Broker: %v",resGID.PendingBroke)
-		}
+	link := CusGuaID{}
+	link.CardID = cardid
+	link.GuaranteeID = guaranteeID.GuaranteeID
+	link.Salt = hex.EncodeToString(salt)
+	err = putCusGuaID(stub, link)
+	if err != nil {
+		return nil, err
 	}
-	brkAsByte,err := stub.GetState(string(brkNO))
-	resBrk := Broker{}
-	json.Unmarshal(brkAsByte,&resBrk)
-	for i := len(resBrk.PendingCustomer) -1 ;i <0;i-- {
-		if resBrk.PendingCustomer[i] == gid {
-			fmt.Printf("before append in Pending Customer: %v",resBrk.PendingCustomer)
-			resBrk.PendingCustomer = append(resBrk.PendingCustomer[:i],resBrk.PendingCustomer[i+1:]...) //remove from pending of struct Broker
-			resBrk.rejectCustomer =append(resBrk.rejectCustomer,gid) //add to reject broker of struct broker
-			fmt.Printf("after append in Pending Customer: %v",resBrk.PendingCustomer)
-			fmt.Printf("after append in Pending Customer: %v",resBrk.rejectCustomer)
-		}
+	err = writeCustomer(stub, res, link)
+	if err != nil {
+		return nil, err
 	}
-	//write key guarantee ID
-	jsonGIDAsbyte,_ := json.Marshal(resGID)
-	err = stub.PutState(GuaranteeIDKey + gid,jsonGIDAsbyte)
-	if err != nil  {
-		return nil, errors.New("Can't put state  Guarantee ID")
+	err = putNameEntry(stub, name, gid)
+	if err != nil {
+		return nil, err
 	}
-	//write key broker number
-	jsonBrkAsByte,_ := json.Marshal(resBrk)
-	err = stub.PutState(string(brkNO), jsonBrkAsByte)
+
+	//add the customer to the index listCustomers pages through
+	err = putIndexEntry(stub, customerIndexStr, guaranteeID.GuaranteeID)
 	if err != nil {
-		return nil, errors.New("Can't put stat Broker number")
+		return nil, err
 	}
 
-	return nil,nil
+	fmt.Println("- end init customer")
+	return nil, nil
 }
+
 // ============================================================================================================================
-// Write - write variable into chaincode state
+// Update Customer - edit an existing customer's profile in place, store into chaincode state. Only the customer, the
+// holder of its record or an admin can do it
 // ============================================================================================================================
-func (t *SimpleChaincode) Write(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var name, value string // Entities
+func (t *SimpleChaincode) updatecustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
-	fmt.Println("running write()")
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2. name of the variable and value to set")
+	//no arguments, the customer comes in the transient map, see customerInput
+	fmt.Println("- start update customer")
+	input, err := transientCustomer(stub)
+	if err != nil {
+		return nil, err
 	}
+	cardid := input.CardID
+	name := input.Name
+	telno := strings.ToLower(input.TelNo)
+	occupation := strings.ToLower(input.Occupation)
 
-	name = args[0] //rename for funsies
-	value = args[1]
-	err = stub.PutState(name, []byte(value)) //write the variable into the chaincode state
+	//the customer must already exist under its card id
+	link, err := getCusGuaID(stub, cardid)
+	if err != nil {
+		fmt.Println("This customer does not exist")
+		return nil, err
+	}
+	gua, err := getGuarantee(stub, link.GuaranteeID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := getCustomer(stub, link.GuaranteeID)
 	if err != nil {
 		return nil, err
 	}
+	err = assertCustodian(stub, gua, res)
+	if err != nil {
+		return nil, err
+	}
+
+	oldName := res.Name
+	res.Name = name
+	res.TelNo = telno
+	res.Occupation = occupation
+	input.profile(&res)
+
+	err = putCustomer(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	if name != oldName { //a rename moves the name entry
+		err = delNameEntry(stub, oldName, link.GuaranteeID)
+		if err != nil {
+			return nil, err
+		}
+		err = putNameEntry(stub, name, link.GuaranteeID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//the guarantee id is deliberately left alone, only its hash moves. It is the handle brokers hold and every consent
+	//refers to it, so it never changes once newcustomer has made it
+	fmt.Println("- end update customer")
 	return nil, nil
 }
 
 // ============================================================================================================================
-// Init Customer - create a new customer, store into chaincode state
+// Delete Customer - erase a customer and everything pointing at it, leave a tombstone for brokers. Only the customer,
+// the holder of its record or an admin can do it
 // ============================================================================================================================
-func (t *SimpleChaincode) newcustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *SimpleChaincode) deletecustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
 
-	//   0        1       2        3           4          5
-	// "name", "telno", "age", "occupation", "cardid", "creator"
-	if len(args) != 6 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	//      0
+	// "guaranteeid"
+	gid := args[0]
+
+	fmt.Println("- start delete customer")
+	gua, err := getGuarantee(stub, gid)
+	if err != nil {
+		return nil, err
 	}
 
-	//input sanitation
-	fmt.Println("- start init customer")
-	if len(args[0]) <= 0 {
-		fmt.Println("- not pass0 init customer")
-		return nil, errors.New("1st argument must be a non-empty string")
+	res, err := getCustomer(stub, gid)
+	if err != nil {
+		return nil, err
+	}
+	err = assertCustodian(stub, gua, res)
+	if err != nil {
+		return nil, err
 	}
-	if len(args[1]) <= 0 {
-		fmt.Println("- not pass1 init customer")
-		return nil, errors.New("2nd argument must be a non-empty string")
+
+	//remove the private customer entries and links, then the public guarantee id record
+	err = delNameEntry(stub, res.Name, gid)
+	if err != nil {
+		return nil, err
 	}
-	if len(args[2]) <= 0 {
-		fmt.Println("- not pass2 init customer")
-		return nil, errors.New("3rd argument must be a non-empty string")
+	err = stub.DelPrivateData(customerCollection, customerKey+gid)
+	if err != nil {
+		return nil, err
 	}
-	if len(args[3]) <= 0 {
-		fmt.Println("- not pass3 init customer")
-		return nil, errors.New("4th argument must be a non-empty string")
+	err = delCusGuaID(stub, gua.CustomerID)
+	if err != nil {
+		return nil, err
 	}
-	if len(args[4]) <= 0 {
-		fmt.Println("- not pass4 init customer")
-		return nil, errors.New("5th argument must be a non-empty string")
+	err = stub.DelPrivateData(customerCollection, GuaranteeIDKey+gid)
+	if err != nil {
+		return nil, err
 	}
-	if len(args[5]) <= 0 {
-		fmt.Println("- not pass5 init customer")
-		return nil, errors.New("6th argument must be a non-empty string")
+	err = stub.DelState(GuaranteeIDKey + gid)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("- pass1 init customer")
-	name := args[0]
-	telno := strings.ToLower(args[1])
-	age, err := strconv.Atoi(args[2])
+	//drop the guarantee id from the customer index
+	err = delIndexEntry(stub, customerIndexStr, gid)
 	if err != nil {
-		return nil, errors.New("3rd argument must be a numeric string")
+		return nil, err
 	}
-	occupation := strings.ToLower(args[3])
-	cardid := args[4]
-	creator := args[5]
 
-	fmt.Println("- pass2 init customer")
-	//check if customer already exists
-	customerAsBytes, err := stub.GetState(customerKey + name)
+	//every consent given against the guarantee id ends as erased, the consent event is each broker's notice
+	err = eraseConsents(stub, gid)
 	if err != nil {
-		return nil, errors.New("Failed to get customer name")
+		return nil, err
 	}
-	res := Customer{}
-	json.Unmarshal(customerAsBytes, &res)
-	if res.Name == name {
-		fmt.Println("This customer arleady exists: " + name)
-		fmt.Println(res)
-		return nil, errors.New("This customer arleady exists") //all stop a customer by this name exists
+	err = delRequests(stub, gid)
+	if err != nil {
+		return nil, err
 	}
 
-	res.Name = name
-	res.TelNo = telno
-	res.Age = age
-	res.Occupation = occupation
-	res.CardID = cardid
-	res.Creator = creator
-	//build the customer json string manually
-	//str := `{"name": "` + name + `", "telno": "` + telno + `", "size": ` + strconv.Itoa(size) + `, "user": "` + user + `"}`
-	//err = stub.PutState(name, []byte(str)) //store customer with id as key
-	str, err := json.Marshal(res)
-	err = stub.PutState(customerKey+name, str)
+	//leave a tombstone so a broker holding this guarantee id can tell it was erased rather than never existed
+	erasedAt, err := txTimestamp(stub)
 	if err != nil {
 		return nil, err
 	}
-	err = stub.PutState(customerKey+cardid, str)
+	tombstone := Erasure{}
+	tombstone.GuaranteeID = gid
+	tombstone.Erased = true
+	tombstone.ErasedAt = erasedAt
+	tombstone.TxID = stub.GetTxID()
+	jsonAsBytes, _ := json.Marshal(tombstone)
+	err = stub.PutState(ErasedKey+gid, jsonAsBytes)
 	if err != nil {
 		return nil, err
 	}
 
-	sha256AsByte := sha256.Sum256(str)
+	fmt.Println("- end delete customer")
+	return nil, nil
+}
 
-	guaranteeID := GuaranteeID{}
-	var emptyIntArray []int
-	guaranteeID.GuaranteeID = strings.ToUpper(hex.EncodeToString(sha256AsByte[:]))
-	guaranteeID.CustomerID = res.CardID
-	guaranteeID.AllowBroke = emptyIntArray
-	guaranteeID.PendingBroke = emptyIntArray
+// ============================================================================================================================
+// Set User - transfer a customer record to another creator, only the current creator or an admin may do it. It takes
+// the guarantee id, so the card id never goes in the transaction
+// ============================================================================================================================
+func (t *SimpleChaincode) setuser(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
 
-	str, err = json.Marshal(guaranteeID)
-	err = stub.PutState(GuaranteeIDKey+guaranteeID.GuaranteeID, str)
+	//      0             1             2
+	// "guaranteeid", "creator", "creatormspid"
+	gid := args[0]
+	creator := args[1]
+	creatorMSPID := args[2]
+
+	fmt.Println("- start set user")
+	res, err := getCustomer(stub, gid)
 	if err != nil {
 		return nil, err
 	}
 
-	//gid := GuaranteeID{}
-	// h := sha1.New()
-	// h.Write([]byte(res))
-	// gid.GuaranteeID = h.Sum()
+	//the acting identity is whoever signed the proposal, never an argument
+	actingUser, actingMSPID, err := submitter(stub)
+	if err != nil {
+		return nil, err
+	}
+	if actingUser != res.Creator || actingMSPID != res.CreatorMSPID {
+		admin, err := isAdmin(stub)
+		if err != nil {
+			return nil, err
+		}
+		if !admin {
+			return nil, permissionDenied("", "Only the current creator or an admin may transfer this customer")
+		}
+	}
+	if creator == res.Creator && creatorMSPID == res.CreatorMSPID {
+		return nil, alreadyExists("creator", "This customer already belongs to "+creator)
+	}
 
-	//get the customer index-
-	customersAsBytes, err := stub.GetState(customerIndexStr)
+	changedAt, err := txTimestamp(stub)
 	if err != nil {
-		return nil, errors.New("Failed to get customer index")
+		return nil, err
 	}
-	var customerIndex []string
-	json.Unmarshal(customersAsBytes, &customerIndex) //un stringify it aka JSON.parse()
+	change := CreatorChange{}
+	change.Creator = res.Creator
+	change.CreatorMSPID = res.CreatorMSPID
+	change.ChangedBy = actingUser
+	change.ChangedAt = changedAt
+	res.PreviousCreators = append(res.PreviousCreators, change)
+	res.Creator = creator
+	res.CreatorMSPID = creatorMSPID
 
-	//append
-	customerIndex = append(customerIndex, cardid) //add customer name to index list
-	fmt.Println("! customer index: ", customerIndex)
-	jsonAsBytes, _ := json.Marshal(customerIndex)
-	err = stub.PutState(customerIndexStr, jsonAsBytes) //store name of customer
+	err = putCustomer(stub, res)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Println("- end init customer")
+	fmt.Println("- end set user")
 	return nil, nil
 }
 
@@ -662,41 +1039,34 @@ func (t *SimpleChaincode) newbroke(stub shim.ChaincodeStubInterface, args []stri
 
 	//   0        1
 	// "name", "brokeID"
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 4")
-	}
-
-	//input sanitation
-	fmt.Println("- start init customer")
-	if len(args[0]) <= 0 {
-		return nil, errors.New("1st argument must be a non-empty string")
-	}
-	if len(args[1]) <= 0 {
-		return nil, errors.New("2nd argument must be a non-empty string")
-	}
-
+	fmt.Println("- start init broker")
 	name := args[0]
-	brokeNoAsString := args[1]
-	brokeNo, err := strconv.Atoi(args[1])
-	if err != nil {
-		return nil, errors.New("2nd argument must be a numeric string")
-	}
+	brokeNo, _ := strconv.Atoi(args[1])
+	brokeNoAsString := strconv.Itoa(brokeNo) //every read keys it the same way
 
 	//check if broker already exists
-	brokerAsBytes, err := stub.GetState(brokeNoAsString)
+	brokerAsBytes, err := stub.GetState(BrokerKey + brokeNoAsString)
 	if err != nil {
-		return nil, errors.New("Failed to get broker name")
+		return nil, ledgerError(err, "Failed to get broker name")
 	}
 	res := Broker{}
 	json.Unmarshal(brokerAsBytes, &res)
 	if res.BrokerNo == brokeNo {
-		fmt.Println("This broker arleady exists: " + name)
+		fmt.Println("This broker already exists: " + name)
 		fmt.Println(res)
-		return nil, errors.New("This broker arleady exists") //all stop a broker by this name exists
+		return nil, alreadyExists("brokeno", "This broker already exists") //all stop a broker by this name exists
+	}
+
+	//bind the broker to whoever registers it
+	subject, mspid, err := submitter(stub)
+	if err != nil {
+		return nil, err
 	}
 
 	res.Name = name
 	res.BrokerNo = brokeNo
+	res.Subject = subject
+	res.MSPID = mspid
 	//build the broker json string manually
 	//str := `{"name": "` + name + `", "telno": "` + telno + `", "size": ` + strconv.Itoa(size) + `, "user": "` + user + `"}`
 	//err = stub.PutState(name, []byte(str)) //store broker with id as key
@@ -706,20 +1076,60 @@ func (t *SimpleChaincode) newbroke(stub shim.ChaincodeStubInterface, args []stri
 		return nil, err
 	}
 
-	//get the broker index-
-	brokersAsBytes, err := stub.GetState(brokerIndexStr)
+	//add the broker to the index listBrokers pages through
+	err = putIndexEntry(stub, brokerIndexStr, brokeNoAsString)
 	if err != nil {
-		return nil, errors.New("Failed to get broker index")
+		return nil, err
 	}
-	var brokerIndex []string
-	json.Unmarshal(brokersAsBytes, &brokerIndex) //un stringify it aka JSON.parse()
-
-	//append
-	brokerIndex = append(brokerIndex, brokeNoAsString) //add broker name to index list
-	fmt.Println("! broker index: ", brokerIndex)
-	jsonAsBytes, _ := json.Marshal(brokerIndex)
-	err = stub.PutState(brokerIndexStr, jsonAsBytes) //store name of broker
 
 	fmt.Println("- end init broker")
 	return nil, nil
-}
\ No newline at end of file
+}
+
+// ============================================================================================================================
+// isAdmin - true if the submitter carries the admin role and its MSP was listed as an admin when the chaincode was
+// initialized. Being a member of a listed MSP is not enough
+// ============================================================================================================================
+func isAdmin(stub shim.ChaincodeStubInterface) (bool, error) {
+	_, mspid, err := submitter(stub)
+	if err != nil {
+		return false, err
+	}
+	if cid.AssertAttributeValue(stub, roleAttr, adminRole) != nil {
+		return false, nil
+	}
+	adminsAsBytes, err := stub.GetState(adminIndexStr)
+	if err != nil {
+		return false, ledgerError(err, "Failed to get admin index")
+	}
+	var admins []string
+	json.Unmarshal(adminsAsBytes, &admins)
+	for _, admin := range admins {
+		if admin == mspid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ============================================================================================================================
+// txTime - the transaction timestamp, the same on every endorsing peer
+// ============================================================================================================================
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, ledgerError(err, "Failed to get transaction timestamp")
+	}
+	return time.Unix(ts.GetSeconds(), int64(ts.GetNanos())).UTC(), nil
+}
+
+// ============================================================================================================================
+// txTimestamp - the transaction timestamp as an RFC3339 string
+// ============================================================================================================================
+func txTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return now.Format(time.RFC3339), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUpdateCustomer(t *testing.T) {
+	s, _ := newWorld(t)
+	input := sampleInput(sampleCardID, sampleName)
+	input.TelNo = "0898765432"
+
+	s.withCustomer(input)
+	s.fails(codePermissionDenied, stranger, "update_customer")
+	for _, caller := range []testIdentity{customer, agent, admin} {
+		input.Occupation = caller.name
+		s.withCustomer(input)
+		s.must(caller, "update_customer")
+
+		res := Customer{}
+		json.Unmarshal(s.must(customer, "readcustomer", sampleCardID), &res)
+		if res.TelNo != input.TelNo || res.Occupation != caller.name {
+			t.Errorf("after update_customer by %s, readcustomer = %+v", caller.name, res)
+		}
+	}
+
+	s.withCustomer(sampleInput("1234567890121", "Somsri"))
+	s.fails(codeNotFound, agent, "update_customer")
+}
+
+// erasable - a world where broker is allowed and stranger, as broker 8, has a request pending. It returns the
+// guarantee id and the two request ids
+func erasable(t *testing.T) (*testStub, string, string, string) {
+	s, gid := newWorld(t)
+	s.must(stranger, "newbroke", "Broker Eight", "8")
+	allowed := s.ask(gid, "name")
+	s.must(customer, "customerallow", allowed)
+	pending := AccessRequest{}
+	json.Unmarshal(s.must(stranger, "requestPermission", gid, "8", "telno", "loan"), &pending)
+	return s, gid, allowed, pending.RequestID
+}
+
+func TestDeleteCustomer(t *testing.T) {
+	s, gid, allowed, pending := erasable(t)
+	s.fails(codePermissionDenied, stranger, "delete", gid)
+	s.fails(codePermissionDenied, broker, "delete", gid)
+	s.must(customer, "delete", gid)
+
+	for _, brokeNo := range []string{sampleBroker, "8"} {
+		if got := s.consent(gid, brokeNo); got.Status != statusErased || len(got.Scope) > 0 || len(got.RequestID) > 0 {
+			t.Errorf("consent with broker %s after delete = %+v", brokeNo, got)
+		}
+	}
+	for _, requestID := range []string{allowed, pending} {
+		if _, ok := s.State[RequestKey+requestID]; ok {
+			t.Errorf("request %s is left after delete", requestID)
+		}
+	}
+
+	tombstone := Erasure{}
+	json.Unmarshal(s.must(broker, "readcustomergid", gid), &tombstone)
+	if !tombstone.Erased || tombstone.GuaranteeID != gid || len(tombstone.ErasedAt) == 0 {
+		t.Errorf("readcustomergid after delete = %+v", tombstone)
+	}
+	s.fails(codeNotFound, customer, "readcustomer", sampleCardID)
+	s.fails(codeNotFound, customer, "delete", gid)
+}
+
+func TestDeleteCustomerByCustodian(t *testing.T) {
+	for _, caller := range []testIdentity{agent, admin} {
+		s, gid := newWorld(t)
+		s.must(caller, "delete", gid)
+	}
+}
+
+func TestSetUser(t *testing.T) {
+	s, gid := newWorld(t)
+	clerk := testIdentity{name: "clerk", mspid: "Org1MSP"}
+	s.fails(codePermissionDenied, stranger, "set_user", gid, "CN=stranger,O=Org2MSP", stranger.mspid)
+	s.fails(codePermissionDenied, clerk, "set_user", gid, "CN=clerk,O=Org1MSP", clerk.mspid)
+	s.must(agent, "set_user", gid, "CN=clerk,O=Org1MSP", clerk.mspid)
+
+	s.withCustomer(sampleInput(sampleCardID, sampleName))
+	s.fails(codePermissionDenied, agent, "update_customer") //no longer holds the record
+	s.withCustomer(sampleInput(sampleCardID, sampleName))
+	s.must(clerk, "update_customer")
+	s.fails(codePermissionDenied, agent, "delete", gid)
+	s.must(clerk, "delete", gid)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// consentObjectType is the composite key of a consent record, guarantee id then broker number
+const consentObjectType = "consent"
+
+// brokerConsentIndex is the same pair keyed the other way round, broker number then guarantee id. Its values are empty,
+// the record itself only lives under consentObjectType
+const brokerConsentIndex = "broker~consent"
+
+// consentEventName is the chaincode event set by every transaction that moves a consent between states
+const consentEventName = "consent"
+
+// consent statuses, as stored on a consent record and sent in consent events
+const (
+	statusNone     = "none"
+	statusPending  = "pending"
+	statusAllowed  = "allowed"
+	statusRejected = "rejected"
+	statusRevoked  = "revoked"
+	statusErased   = "erased"  //the customer was erased, nothing moves the consent on from here
+	statusExpired  = "expired" //never stored, an allowed consent past its expiresat reads back as expired
+)
+
+// Consent is one broker's access to one customer, there is at most one per guarantee id and broker pair
+type Consent struct {
+	GuaranteeID string   `json:"guaranteeid"`
+	BrokerNo    int      `json:"brokerno"`
+	Status      string   `json:"status"`
+	ExpiresAt   string   `json:"expiresat,omitempty"`  //RFC3339, empty when the grant does not lapse
+	Requested   []string `json:"requested,omitempty"`  //Customer fields the broker asked for
+	Scope       []string `json:"scope,omitempty"`      //the part of Requested the customer granted
+	RejectedAt  string   `json:"rejectedat,omitempty"` //RFC3339, when the customer last turned the broker down
+	Reason      string   `json:"reason,omitempty"`     //and why, if they said
+	RevokedBy   string   `json:"revokedby,omitempty"`  //certificate subject of whoever last took an allowed consent back
+	RevokedAt   string   `json:"revokedat,omitempty"`  //RFC3339
+	RequestID   string   `json:"requestid,omitempty"`  //the access request the consent was last moved by
+}
+
+// ConsentEvent is the payload of the consent event. An erasure moves every consent on the guarantee id at once, and a
+// transaction only carries one event, so its event lists them in Brokers in place of BrokerNo and OldStatus
+type ConsentEvent struct {
+	GuaranteeID string        `json:"guaranteeid"`
+	BrokerNo    int           `json:"brokerno,omitempty"`
+	OldStatus   string        `json:"oldstatus,omitempty"`
+	NewStatus   string        `json:"newstatus"`
+	RequestID   string        `json:"requestid,omitempty"`
+	Brokers     []consentMove `json:"brokers,omitempty"`
+	TxID        string        `json:"txid"`
+}
+
+// consentMove is one broker's consent in an erasure's event, and the status it was moved from
+type consentMove struct {
+	BrokerNo  int    `json:"brokerno"`
+	OldStatus string `json:"oldstatus"`
+}
+
+// legacyGrants are the lists guarantee id and broker records kept before consent records, each side naming the other
+type legacyGrants struct {
+	AllowBroke      []int    `json:"allowbroke"`
+	PendingBroke    []int    `json:"pendingbroke"`
+	AllowCustomer   []string `json:"allowcustomer"`
+	PendingCustomer []string `json:"pendingcustomer"`
+}
+
+// legacyGrantFields are their json names, migrateconsents takes them off the records it moves them from
+var legacyGrantFields = []string{"allowbroke", "pendingbroke", "allowcustomer", "pendingcustomer", "rejectcustomer"}
+
+// consentMigration is what migrateconsents returns, how many consent records it made of each status
+type consentMigration struct {
+	Allowed int `json:"allowed"`
+	Pending int `json:"pending"`
+}
+
+// ============================================================================================================================
+// emitConsentEvent - tell listeners on the event hub that a consent moved from one status to another
+// ============================================================================================================================
+func emitConsentEvent(stub shim.ChaincodeStubInterface, consent Consent, oldStatus, newStatus string) error {
+	event := ConsentEvent{}
+	event.GuaranteeID = consent.GuaranteeID
+	event.BrokerNo = consent.BrokerNo
+	event.OldStatus = oldStatus
+	event.NewStatus = newStatus
+	event.RequestID = consent.RequestID
+	return setConsentEvent(stub, event)
+}
+
+// setConsentEvent - set the consent event on the transaction
+func setConsentEvent(stub shim.ChaincodeStubInterface, event ConsentEvent) error {
+	event.TxID = stub.GetTxID()
+	jsonAsBytes, _ := json.Marshal(event)
+	err := stub.SetEvent(consentEventName, jsonAsBytes)
+	if err != nil {
+		return ledgerError(err, "Failed to set consent event")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// expire - an allowed consent whose expiry has passed is treated as revoked, now is the transaction timestamp
+// ============================================================================================================================
+func expire(consent Consent, now string) Consent {
+	//both are RFC3339 in UTC, so they order the same as strings
+	if consent.Status == statusAllowed && len(consent.ExpiresAt) > 0 && consent.ExpiresAt <= now {
+		consent.Status = statusExpired
+	}
+	return consent
+}
+
+// ============================================================================================================================
+// consentExpiry - when a grant made now for the given number of days lapses, no days means it never does
+// ============================================================================================================================
+func consentExpiry(stub shim.ChaincodeStubInterface, days int) (string, error) {
+	if days <= 0 {
+		return "", nil
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return now.AddDate(0, 0, days).Format(time.RFC3339), nil
+}
+
+// ============================================================================================================================
+// getConsent - read the consent for a guarantee id and broker, a pair that was never linked comes back as statusNone
+// ============================================================================================================================
+func getConsent(stub shim.ChaincodeStubInterface, gid string, brokeNo int) (Consent, error) {
+	consent := Consent{GuaranteeID: gid, BrokerNo: brokeNo, Status: statusNone}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return consent, err
+	}
+
+	key, err := stub.CreateCompositeKey(consentObjectType, []string{gid, strconv.Itoa(brokeNo)})
+	if err != nil {
+		return consent, ledgerError(err, "Failed to create consent key")
+	}
+	consentAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return consent, ledgerError(err, "Failed to get consent")
+	}
+	if len(consentAsBytes) > 0 {
+		json.Unmarshal(consentAsBytes, &consent)
+	}
+	return expire(consent, now), nil
+}
+
+// ============================================================================================================================
+// putConsent - write a consent record and its broker index entry
+// ============================================================================================================================
+func putConsent(stub shim.ChaincodeStubInterface, consent Consent) error {
+	brokeNoAsString := strconv.Itoa(consent.BrokerNo)
+	key, err := stub.CreateCompositeKey(consentObjectType, []string{consent.GuaranteeID, brokeNoAsString})
+	if err != nil {
+		return ledgerError(err, "Failed to create consent key")
+	}
+	indexKey, err := stub.CreateCompositeKey(brokerConsentIndex, []string{brokeNoAsString, consent.GuaranteeID})
+	if err != nil {
+		return ledgerError(err, "Failed to create consent index key")
+	}
+
+	jsonAsBytes, _ := json.Marshal(consent)
+	err = stub.PutState(key, jsonAsBytes)
+	if err != nil {
+		return ledgerError(err, "Failed to put consent")
+	}
+	err = stub.PutState(indexKey, []byte{0x00}) //the index only needs the key, but an empty value would delete it
+	if err != nil {
+		return ledgerError(err, "Failed to put consent index")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// eraseConsents - move every consent given against a guarantee id to statusErased, keeping only who it was between, and
+// tell the event hub about all of them in one consent event
+// ============================================================================================================================
+func eraseConsents(stub shim.ChaincodeStubInterface, gid string) error {
+	consents, err := consentsByGuarantee(stub, gid)
+	if err != nil {
+		return err
+	}
+	if len(consents) == 0 {
+		return nil
+	}
+
+	event := ConsentEvent{}
+	event.GuaranteeID = gid
+	event.NewStatus = statusErased
+	for _, consent := range consents {
+		//the reason and who revoked it came from the customer, they go with the rest of the customer's data
+		err = putConsent(stub, Consent{GuaranteeID: gid, BrokerNo: consent.BrokerNo, Status: statusErased})
+		if err != nil {
+			return err
+		}
+		event.Brokers = append(event.Brokers, consentMove{BrokerNo: consent.BrokerNo, OldStatus: consent.Status})
+	}
+	return setConsentEvent(stub, event)
+}
+
+// ============================================================================================================================
+// setConsentStatus - move a consent to a new status and tell the event hub about it
+// ============================================================================================================================
+func setConsentStatus(stub shim.ChaincodeStubInterface, consent Consent, status string) error {
+	oldStatus := consent.Status
+	consent.Status = status
+	err := putConsent(stub, consent)
+	if err != nil {
+		return err
+	}
+	return emitConsentEvent(stub, consent, oldStatus, status)
+}
+
+// ============================================================================================================================
+// consentsByGuarantee - every consent given against a guarantee id, by partial key range scan
+// ============================================================================================================================
+func consentsByGuarantee(stub shim.ChaincodeStubInterface, gid string) ([]Consent, error) {
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(consentObjectType, []string{gid})
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get consents for "+gid)
+	}
+	defer resultsIterator.Close()
+
+	consents := []Consent{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, ledgerError(err, "Failed to iterate consents for "+gid)
+		}
+		consent := Consent{}
+		json.Unmarshal(kv.Value, &consent)
+		consents = append(consents, expire(consent, now))
+	}
+	return consents, nil
+}
+
+// ============================================================================================================================
+// consentsByBroker - every consent a broker holds or asked for, by partial key range scan over the broker index
+// ============================================================================================================================
+func consentsByBroker(stub shim.ChaincodeStubInterface, brokeNo int) ([]Consent, error) {
+	brokeNoAsString := strconv.Itoa(brokeNo)
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(brokerConsentIndex, []string{brokeNoAsString})
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get consents for broker "+brokeNoAsString)
+	}
+	defer resultsIterator.Close()
+
+	consents := []Consent{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, ledgerError(err, "Failed to iterate consents for broker "+brokeNoAsString)
+		}
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, ledgerError(err, "Failed to split consent index key")
+		}
+		consent, err := getConsent(stub, keyParts[1], brokeNo)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, consent)
+	}
+	return consents, nil
+}
+
+// ============================================================================================================================
+// checkConsentParties - both the guarantee id and the broker have to exist before a consent between them can change
+// ============================================================================================================================
+func checkConsentParties(stub shim.ChaincodeStubInterface, gid string, brokeNo int) (GuaranteeID, Broker, error) {
+	broker := Broker{}
+	gua, err := getGuarantee(stub, gid)
+	if err != nil {
+		return gua, broker, err
+	}
+
+	brokerAsBytes, err := stub.GetState(BrokerKey + strconv.Itoa(brokeNo))
+	if err != nil {
+		return gua, broker, ledgerError(err, "Failed to get state for broker "+strconv.Itoa(brokeNo))
+	}
+	if len(brokerAsBytes) == 0 {
+		return gua, broker, notFound("brokeno", "This broker does not exist")
+	}
+	json.Unmarshal(brokerAsBytes, &broker)
+	return gua, broker, nil
+}
+
+// ============================================================================================================================
+// Migrate Consents - turn the grants guarantee id and broker records listed before consent records into consent records,
+// then take the lists off them. A grant on either side counts, and allowed wins over pending. Only an admin can do it,
+// once after the upgrade, and running it again finds nothing left to do
+// ============================================================================================================================
+func (t *SimpleChaincode) migrateconsents(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	admin, err := isAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return nil, permissionDenied("", "Only an admin can migrate consents")
+	}
+
+	type pair struct {
+		gid     string
+		brokeNo int
+	}
+	grants := map[pair]string{}
+	grant := func(gid string, brokeNo int, status string) {
+		if grants[pair{gid, brokeNo}] != statusAllowed {
+			grants[pair{gid, brokeNo}] = status
+		}
+	}
+	err = stripLegacyGrants(stub, GuaranteeIDKey, func(key string, old legacyGrants) {
+		gid := strings.TrimPrefix(key, GuaranteeIDKey)
+		for _, brokeNo := range old.PendingBroke {
+			grant(gid, brokeNo, statusPending)
+		}
+		for _, brokeNo := range old.AllowBroke {
+			grant(gid, brokeNo, statusAllowed)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = stripLegacyGrants(stub, BrokerKey, func(key string, old legacyGrants) {
+		brokeNo, _ := strconv.Atoi(strings.TrimPrefix(key, BrokerKey))
+		for _, gid := range old.PendingCustomer {
+			grant(gid, brokeNo, statusPending)
+		}
+		for _, gid := range old.AllowCustomer {
+			grant(gid, brokeNo, statusAllowed)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([]pair, 0, len(grants))
+	for p := range grants {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].gid != pairs[j].gid {
+			return pairs[i].gid < pairs[j].gid
+		}
+		return pairs[i].brokeNo < pairs[j].brokeNo
+	})
+
+	migration := consentMigration{}
+	for _, p := range pairs {
+		_, _, err := checkConsentParties(stub, p.gid, p.brokeNo)
+		if kerr, ok := err.(*kycError); ok && kerr.Code == codeNotFound {
+			continue //a grant to a customer or broker that is gone is dropped
+		}
+		if err != nil {
+			return nil, err
+		}
+		consent, err := getConsent(stub, p.gid, p.brokeNo)
+		if err != nil {
+			return nil, err
+		}
+		if consent.Status != statusNone {
+			continue //already a consent record, it is newer than the lists
+		}
+
+		//no consent event, nothing changed for either side. With no scope the grant covers every field, as it did
+		consent.Status = grants[p]
+		err = putConsent(stub, consent)
+		if err != nil {
+			return nil, err
+		}
+		if consent.Status == statusAllowed {
+			migration.Allowed++
+		} else {
+			migration.Pending++
+		}
+	}
+
+	jsonAsBytes, _ := json.Marshal(migration)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// stripLegacyGrants - hand the grant lists of every record under a key prefix to found, and write the record back
+// without them, by range scan
+// ============================================================================================================================
+func stripLegacyGrants(stub shim.ChaincodeStubInterface, recordKey string, found func(key string, old legacyGrants)) error {
+	resultsIterator, err := stub.GetStateByRange(recordKey, recordKey+"~") //ids are letters and digits, all below ~
+	if err != nil {
+		return ledgerError(err, "Failed to get records under "+recordKey)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return ledgerError(err, "Failed to iterate records under "+recordKey)
+		}
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(kv.Value, &fields)
+		legacy := false
+		for _, field := range legacyGrantFields {
+			if _, ok := fields[field]; ok {
+				legacy = true
+				delete(fields, field)
+			}
+		}
+		if !legacy {
+			continue
+		}
+
+		old := legacyGrants{}
+		json.Unmarshal(kv.Value, &old)
+		found(kv.Key, old)
+		jsonAsBytes, _ := json.Marshal(fields)
+		err = stub.PutState(kv.Key, jsonAsBytes)
+		if err != nil {
+			return ledgerError(err, "Failed to put "+kv.Key)
+		}
+	}
+	return nil
+}
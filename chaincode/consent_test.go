@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateConsents(t *testing.T) {
+	s, gid := newWorld(t)
+	s.seed(GuaranteeIDKey+"LEGACY1", `{"guaranteeid":"LEGACY1","customerid":"1234567890121","allowbroke":[7],"pendingbroke":[8]}`)
+	s.seed(BrokerKey+"8", `{"name":"Broker Eight","brokerno":8,"allowcustomer":["LEGACY1"],"pendingcustomer":["GONE"]}`)
+	s.seed(BrokerKey+"7", `{"name":"Broker Seven","brokerno":7,"allowcustomer":null,"pendingcustomer":["`+gid+`"]}`)
+
+	s.fails(codePermissionDenied, agent, "migrateconsents")
+	migration := consentMigration{}
+	json.Unmarshal(s.must(admin, "migrateconsents"), &migration)
+	if migration != (consentMigration{Allowed: 2, Pending: 1}) {
+		t.Errorf("migrateconsents = %+v, want 2 allowed and 1 pending", migration)
+	}
+
+	tests := []struct {
+		gid, brokeNo, want string
+	}{
+		{"LEGACY1", "7", statusAllowed},
+		{"LEGACY1", "8", statusAllowed}, //pending on one side, allowed on the other
+		{gid, "7", statusPending},
+		{"GONE", "8", statusNone},
+	}
+	for _, tt := range tests {
+		if got := s.consent(tt.gid, tt.brokeNo).Status; got != tt.want {
+			t.Errorf("consent %s/%s = %s, want %s", tt.gid, tt.brokeNo, got, tt.want)
+		}
+	}
+	for _, key := range []string{GuaranteeIDKey + "LEGACY1", BrokerKey + "7", BrokerKey + "8"} {
+		for _, field := range legacyGrantFields {
+			if strings.Contains(string(s.State[key]), `"`+field+`"`) {
+				t.Errorf("%s still has %s: %s", key, field, s.State[key])
+			}
+		}
+	}
+
+	json.Unmarshal(s.must(admin, "migrateconsents"), &migration)
+	if migration != (consentMigration{}) {
+		t.Errorf("second migrateconsents = %+v, want nothing", migration)
+	}
+	var requests []AccessRequest
+	json.Unmarshal(s.must(admin, "migraterequests"), &requests)
+	if len(requests) != 1 || requests[0].GuaranteeID != gid || requests[0].BrokerNo != 7 {
+		t.Errorf("migraterequests = %+v, want one for %s and broker 7", requests, gid)
+	}
+}
+
+func TestConsentEvents(t *testing.T) {
+	s, gid := newWorld(t)
+	first := s.ask(gid, "name")
+	if got := s.consentEvent(); got.OldStatus != statusNone || got.NewStatus != statusPending || got.RequestID != first {
+		t.Errorf("requestPermission event = %+v", got)
+	}
+	s.must(customer, "rejectBroker", first)
+	if got := s.consentEvent(); got.OldStatus != statusPending || got.NewStatus != statusRejected {
+		t.Errorf("rejectBroker event = %+v", got)
+	}
+
+	second := s.ask(gid, "name")
+	s.must(customer, "customerallow", second)
+	got := s.consentEvent()
+	if got.GuaranteeID != gid || got.BrokerNo != 7 || got.OldStatus != statusPending || got.NewStatus != statusAllowed {
+		t.Errorf("customerallow event = %+v", got)
+	}
+	if got.RequestID != second || len(got.TxID) == 0 {
+		t.Errorf("customerallow event = %+v, want request %s and a txid", got, second)
+	}
+	s.must(customer, "cancelAllow", gid, sampleBroker)
+	if got := s.consentEvent(); got.OldStatus != statusAllowed || got.NewStatus != statusRevoked {
+		t.Errorf("cancelAllow event = %+v", got)
+	}
+
+	s.must(stranger, "newbroke", "Broker Eight", "8")
+	s.must(stranger, "requestPermission", gid, "8", "telno", "loan")
+	s.must(customer, "delete", gid)
+	got = s.consentEvent()
+	want := []consentMove{{BrokerNo: 7, OldStatus: statusRevoked}, {BrokerNo: 8, OldStatus: statusPending}}
+	if got.NewStatus != statusErased || !reflect.DeepEqual(got.Brokers, want) || got.BrokerNo != 0 {
+		t.Errorf("delete event = %+v, want brokers %+v", got, want)
+	}
+}
+
+func TestConsentExpiry(t *testing.T) {
+	s, gid := newWorld(t)
+	s.must(customer, "customerallow", s.ask(gid, "name"), "1")
+	s.must(broker, "readcustomerbroker", gid, sampleBroker)
+
+	s.now = s.now.AddDate(0, 0, 1)
+	s.fails(codePermissionDenied, broker, "readcustomerbroker", gid, sampleBroker)
+	detail := guaranteeDetail{}
+	json.Unmarshal(s.must(customer, "readcustomergid", gid), &detail)
+	if len(detail.Consents) != 1 || detail.Consents[0].Status != statusExpired {
+		t.Errorf("consents after the grant lapsed = %+v", detail.Consents)
+	}
+
+	s.fails(codeInvalidArgument, customer, "renewAllow", gid, sampleBroker, "0")
+	s.fails(codePermissionDenied, broker, "renewAllow", gid, sampleBroker, "30")
+	s.must(customer, "renewAllow", gid, sampleBroker, "30")
+	if got := s.consentEvent(); got.OldStatus != statusExpired || got.NewStatus != statusAllowed {
+		t.Errorf("renewAllow event = %+v", got)
+	}
+	s.now = s.now.AddDate(0, 0, 29)
+	s.must(broker, "readcustomerbroker", gid, sampleBroker)
+	if got, want := s.consent(gid, sampleBroker).ExpiresAt, "2020-07-02T09:00:00Z"; got != want {
+		t.Errorf("renewed consent expires at %s, want %s", got, want)
+	}
+
+	s.must(customer, "cancelAllow", gid, sampleBroker)
+	s.fails(codeNotFound, customer, "renewAllow", gid, sampleBroker, "30")
+}
+
+func TestRejectBrokerWithReason(t *testing.T) {
+	s, gid := newWorld(t)
+	requestID := s.ask(gid, "name")
+	s.fails(codePermissionDenied, broker, "rejectBroker", requestID, "no")
+	s.must(customer, "rejectBroker", requestID, "I do not know this broker")
+
+	customerSide := guaranteeDetail{}
+	json.Unmarshal(s.must(customer, "readcustomergid", gid), &customerSide)
+	brokerSide := brokerDetail{}
+	json.Unmarshal(s.must(broker, "readbroker", sampleBroker), &brokerSide)
+	for side, consents := range map[string][]Consent{"customer": customerSide.Consents, "broker": brokerSide.Consents} {
+		if len(consents) != 1 {
+			t.Fatalf("the %s sees consents %+v", side, consents)
+		}
+		got := consents[0]
+		if got.Status != statusRejected || got.Reason != "I do not know this broker" || got.RejectedAt != "2020-06-01T09:00:00Z" {
+			t.Errorf("the %s sees %+v", side, got)
+		}
+	}
+	if got := s.request(requestID).Status; got != statusRejected {
+		t.Errorf("rejected request = %s", got)
+	}
+
+	//a rejection without a reason leaves none behind from the one before
+	s.must(customer, "rejectBroker", s.ask(gid, "telno"))
+	if got := s.consent(gid, sampleBroker); got.Status != statusRejected || len(got.Reason) > 0 {
+		t.Errorf("consent after a second rejection = %+v", got)
+	}
+}
+
+func TestCancelAllow(t *testing.T) {
+	s, gid := newWorld(t)
+	s.fails(codeNotFound, customer, "cancelAllow", gid, sampleBroker) //nothing to revoke
+	requestID := s.ask(gid, "name,telno")
+	s.fails(codeNotFound, customer, "cancelAllow", gid, sampleBroker) //still pending
+	s.must(customer, "customerallow", requestID)
+
+	s.now = s.now.Add(time.Hour)
+	s.fails(codePermissionDenied, broker, "cancelAllow", gid, sampleBroker)
+	s.must(customer, "cancelAllow", gid, sampleBroker)
+	got := s.consent(gid, sampleBroker)
+	if got.Status != statusRevoked || got.RevokedBy != "CN=somchai,O=Org1MSP" || got.RevokedAt != "2020-06-01T10:00:00Z" {
+		t.Errorf("revoked consent = %+v", got)
+	}
+	if got := s.consentEvent(); got.OldStatus != statusAllowed || got.NewStatus != statusRevoked || got.RequestID != requestID {
+		t.Errorf("cancelAllow event = %+v", got)
+	}
+	if got := s.request(requestID).Status; got != statusRevoked {
+		t.Errorf("request the access was granted on = %s, want revoked", got)
+	}
+	s.fails(codePermissionDenied, broker, "readcustomerbroker", gid, sampleBroker)
+	s.fails(codeNotFound, customer, "cancelAllow", gid, sampleBroker) //already revoked
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// error codes, stable so callers can switch on them instead of parsing messages
+const (
+	codeInvalidArgument  = "INVALID_ARGUMENT"
+	codeUnknownFunction  = "UNKNOWN_FUNCTION"
+	codeNotFound         = "NOT_FOUND"
+	codeAlreadyExists    = "ALREADY_EXISTS"
+	codePermissionDenied = "PERMISSION_DENIED"
+	codeLedger           = "LEDGER_ERROR"
+
+	//a customer field in the wrong format, more specific than codeInvalidArgument
+	codeInvalidNationalID = "INVALID_NATIONAL_ID"
+	codeInvalidPassport   = "INVALID_PASSPORT"
+	codeInvalidPhone      = "INVALID_PHONE"
+	codeInvalidAge        = "INVALID_AGE"
+	codeInvalidDate       = "INVALID_DATE"
+)
+
+// errorStatus is the response status sent back with each code
+var errorStatus = map[string]int32{
+	codeInvalidArgument:  400,
+	codeUnknownFunction:  400,
+	codePermissionDenied: 403,
+	codeNotFound:         404,
+	codeAlreadyExists:    409,
+	codeLedger:           shim.ERROR,
+
+	codeInvalidNationalID: 400,
+	codeInvalidPassport:   400,
+	codeInvalidPhone:      400,
+	codeInvalidAge:        400,
+	codeInvalidDate:       400,
+}
+
+// kycError is the envelope every failed call returns, marshalled into the response message
+type kycError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Arg     string `json:"arg,omitempty"` //name of the offending argument, if there is one
+}
+
+func (e *kycError) Error() string {
+	jsonAsBytes, _ := json.Marshal(e)
+	return string(jsonAsBytes)
+}
+
+func invalidArgument(arg, message string) error {
+	return &kycError{Code: codeInvalidArgument, Message: message, Arg: arg}
+}
+
+func notFound(arg, message string) error {
+	return &kycError{Code: codeNotFound, Message: message, Arg: arg}
+}
+
+func alreadyExists(arg, message string) error {
+	return &kycError{Code: codeAlreadyExists, Message: message, Arg: arg}
+}
+
+func permissionDenied(arg, message string) error {
+	return &kycError{Code: codePermissionDenied, Message: message, Arg: arg}
+}
+
+// invalidField is a customer field that fails its format check, code says which check
+func invalidField(code, arg, message string) error {
+	return &kycError{Code: code, Message: message, Arg: arg}
+}
+
+// ledgerError wraps a failure talking to the peer, the underlying error is kept in the message
+func ledgerError(err error, message string) error {
+	return &kycError{Code: codeLedger, Message: message + ": " + err.Error()}
+}
+
+// ============================================================================================================================
+// errorResponse - the peer response for an error, anything not already a kycError is reported as a ledger error
+// ============================================================================================================================
+func errorResponse(err error) pb.Response {
+	kerr, ok := err.(*kycError)
+	if !ok {
+		kerr = &kycError{Code: codeLedger, Message: err.Error()}
+	}
+	return pb.Response{Status: errorStatus[kerr.Code], Message: kerr.Error()}
+}
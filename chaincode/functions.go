@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// argType is what an argument has to parse as
+type argType int
+
+const (
+	stringArg argType = iota // any string
+	intArg                   // a base 10 integer
+)
+
+// argSpec describes one positional argument of a chaincode function
+type argSpec struct {
+	name     string
+	kind     argType
+	optional bool               // may be empty or left off the end of the argument list
+	variadic bool               // soaks up every remaining argument, only valid on the last spec
+	validate func(string) error // extra check, run once the type is known to be right
+}
+
+// function is one entry in the registry, a handler and the arguments it takes
+type function struct {
+	handler func(*SimpleChaincode, shim.ChaincodeStubInterface, []string) ([]byte, error)
+	args    []argSpec
+}
+
+// arguments shared by several functions
+var (
+	gidArg       = argSpec{name: "guaranteeid"}
+	cardIDArg    = argSpec{name: "cardid"}
+	brokeNoArg   = argSpec{name: "brokeno", kind: intArg, validate: brokerNumber}
+	pageSizeArg  = argSpec{name: "pagesize", kind: intArg, validate: pageSize}
+	bookmarkArg  = argSpec{name: "bookmark", optional: true}
+	fieldsArg    = argSpec{name: "fields", optional: true, validate: validScope}
+	requestIDArg = argSpec{name: "requestid"}
+)
+
+// initFunction is what Init runs. It is kept out of functions, so Invoke can not rewrite the admin list
+var initFunction = function{(*SimpleChaincode).reset, []argSpec{
+	{name: "value", kind: intArg},
+	{name: "adminmspid", optional: true, variadic: true},
+}}
+
+// functions maps every function name Invoke accepts to its handler and argument schema
+var functions = map[string]function{
+	"newcustomer":     {(*SimpleChaincode).newcustomer, []argSpec{}},
+	"update_customer": {(*SimpleChaincode).updatecustomer, []argSpec{}},
+	"delete": {(*SimpleChaincode).deletecustomer, []argSpec{
+		gidArg,
+	}},
+	"set_user": {(*SimpleChaincode).setuser, []argSpec{
+		gidArg,
+		{name: "creator"},
+		{name: "creatormspid"},
+	}},
+	"newbroke": {(*SimpleChaincode).newbroke, []argSpec{
+		{name: "name"},
+		brokeNoArg,
+	}},
+	"requestPermission": {(*SimpleChaincode).requestPermission, []argSpec{
+		gidArg,
+		brokeNoArg,
+		fieldsArg,
+		{name: "purpose"},
+		{name: "message", optional: true},
+	}},
+	"customerallow": {(*SimpleChaincode).customerallow, []argSpec{
+		requestIDArg,
+		{name: "days", kind: intArg, optional: true, validate: positive},
+		fieldsArg,
+	}},
+	"renewAllow": {(*SimpleChaincode).renewAllow, []argSpec{
+		gidArg,
+		brokeNoArg,
+		{name: "days", kind: intArg, validate: positive},
+	}},
+	"cancelAllow": {(*SimpleChaincode).cancelAllow, []argSpec{
+		gidArg,
+		brokeNoArg,
+	}},
+	"rejectBroker": {(*SimpleChaincode).rejectBroker, []argSpec{
+		requestIDArg,
+		{name: "reason", optional: true},
+	}},
+	"read": {(*SimpleChaincode).read, []argSpec{
+		{name: "name"},
+	}},
+	"readcustomer": {(*SimpleChaincode).readcustomer, []argSpec{
+		cardIDArg,
+	}},
+	"readcustomername": {(*SimpleChaincode).readcustomername, []argSpec{
+		{name: "name"},
+	}},
+	"readcustomerconsents": {(*SimpleChaincode).readcustomerconsents, []argSpec{
+		cardIDArg,
+	}},
+	"migrateconsents": {(*SimpleChaincode).migrateconsents, []argSpec{}},
+	"migratecustomers": {(*SimpleChaincode).migratecustomers, []argSpec{
+		{name: "customermspid"},
+	}},
+	"migraterequests": {(*SimpleChaincode).migraterequests, []argSpec{}},
+	"readrequests": {(*SimpleChaincode).readrequests, []argSpec{
+		gidArg,
+	}},
+	"readcustomergid": {(*SimpleChaincode).readcustomergid, []argSpec{
+		gidArg,
+	}},
+	"readcustomerbroker": {(*SimpleChaincode).readcustomerbroker, []argSpec{
+		gidArg,
+		brokeNoArg,
+	}},
+	"readbroker": {(*SimpleChaincode).readbroker, []argSpec{
+		brokeNoArg,
+	}},
+	"readcustomerhistory": {(*SimpleChaincode).readcustomerhistory, []argSpec{
+		cardIDArg,
+	}},
+	"readguaranteehistory": {(*SimpleChaincode).readguaranteehistory, []argSpec{
+		gidArg,
+	}},
+	"readbrokerhistory": {(*SimpleChaincode).readbrokerhistory, []argSpec{
+		brokeNoArg,
+	}},
+	"readconsenthistory": {(*SimpleChaincode).readconsenthistory, []argSpec{
+		gidArg,
+		brokeNoArg,
+	}},
+	"listCustomers": {(*SimpleChaincode).listCustomers, []argSpec{
+		pageSizeArg,
+		bookmarkArg,
+	}},
+	"listBrokers": {(*SimpleChaincode).listBrokers, []argSpec{
+		pageSizeArg,
+		bookmarkArg,
+	}},
+	"migrateindexes": {(*SimpleChaincode).migrateindexes, []argSpec{}},
+}
+
+// ============================================================================================================================
+// call - look the function up, then run it
+// ============================================================================================================================
+func (t *SimpleChaincode) call(stub shim.ChaincodeStubInterface, name string, args []string) pb.Response {
+	fn, ok := functions[name]
+	if !ok {
+		fmt.Println("invoke did not find func: " + name) //error
+		return errorResponse(&kycError{Code: codeUnknownFunction, Message: "Received unknown function invocation " + name})
+	}
+	return fn.run(t, stub, name, args)
+}
+
+// ============================================================================================================================
+// run - check the arguments against the schema, then run the handler
+// ============================================================================================================================
+func (fn function) run(t *SimpleChaincode, stub shim.ChaincodeStubInterface, name string, args []string) pb.Response {
+	if err := fn.check(name, args); err != nil {
+		fmt.Println(err)
+		return errorResponse(err)
+	}
+	for len(args) < len(fn.args) && !fn.args[len(args)].variadic {
+		args = append(args, "") //handlers can index every declared argument, omitted ones are empty
+	}
+	return respond(fn.handler(t, stub, args))
+}
+
+// ============================================================================================================================
+// check - arity first, then every argument's type and validation, in order
+// ============================================================================================================================
+func (fn function) check(name string, args []string) error {
+	least, most := 0, len(fn.args)
+	for i, spec := range fn.args {
+		if !spec.optional {
+			least = i + 1
+		}
+		if spec.variadic {
+			most = -1
+		}
+	}
+	if len(args) < least || (most >= 0 && len(args) > most) {
+		return invalidArgument("", fmt.Sprintf("Incorrect number of arguments for %s. Expecting %s", name, fn.usage()))
+	}
+
+	for i, arg := range args {
+		spec := fn.args[len(fn.args)-1] //anything past the end belongs to the variadic spec
+		if i < len(fn.args) {
+			spec = fn.args[i]
+		}
+		if len(arg) == 0 {
+			if spec.optional {
+				continue
+			}
+			return invalidArgument(spec.name, fmt.Sprintf("Argument %d (%s) of %s must be a non-empty string", i+1, spec.name, name))
+		}
+		if spec.kind == intArg {
+			if _, err := strconv.Atoi(arg); err != nil {
+				return invalidArgument(spec.name, fmt.Sprintf("Argument %d (%s) of %s must be an integer", i+1, spec.name, name))
+			}
+		}
+		if spec.validate != nil {
+			if err := spec.validate(arg); err != nil {
+				return invalidArgument(spec.name, fmt.Sprintf("Argument %d (%s) of %s %s", i+1, spec.name, name, err))
+			}
+		}
+	}
+	return nil
+}
+
+// usage - the argument list as people write it, optional ones in brackets
+func (fn function) usage() string {
+	names := make([]string, len(fn.args))
+	for i, spec := range fn.args {
+		names[i] = spec.name
+		if spec.variadic {
+			names[i] += "..."
+		}
+		if spec.optional {
+			names[i] = "[" + names[i] + "]"
+		}
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// positive - validation for numbers that have to be greater than zero
+func positive(arg string) error {
+	n, _ := strconv.Atoi(arg)
+	if n <= 0 {
+		return errors.New("must be greater than zero")
+	}
+	return nil
+}
+
+// brokerNumber - validation for broker numbers, which go in keys, so each broker may only be written one way
+func brokerNumber(arg string) error {
+	if err := positive(arg); err != nil {
+		return err
+	}
+	n, _ := strconv.Atoi(arg)
+	if strconv.Itoa(n) != arg {
+		return errors.New("must be written without a sign or leading zeros")
+	}
+	return nil
+}
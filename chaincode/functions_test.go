@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	fn := function{nil, []argSpec{
+		gidArg,
+		brokeNoArg,
+		{name: "days", kind: intArg, optional: true, validate: positive},
+		fieldsArg,
+	}}
+	variadic := function{nil, []argSpec{
+		{name: "value", kind: intArg},
+		{name: "adminmspid", optional: true, variadic: true},
+	}}
+	tests := []struct {
+		fn   function
+		args []string
+		arg  string //the argument the error names, empty for no error
+		want string //and a part of its message
+	}{
+		{fn, []string{"G", "7"}, "", ""},
+		{fn, []string{"G", "7", "", "name,telno"}, "", ""},
+		{fn, []string{"G", "7", "30", ""}, "", ""},
+		{fn, []string{"G"}, "", "Expecting (guaranteeid, brokeno, [days], [fields])"},
+		{fn, []string{"G", "7", "30", "name", "x"}, "", "Incorrect number of arguments"},
+		{fn, []string{"", "7"}, "guaranteeid", "must be a non-empty string"},
+		{fn, []string{"G", "seven"}, "brokeno", "must be an integer"},
+		{fn, []string{"G", "0"}, "brokeno", "must be greater than zero"},
+		{fn, []string{"G", "07"}, "brokeno", "without a sign or leading zeros"},
+		{fn, []string{"G", "+7"}, "brokeno", "without a sign or leading zeros"},
+		{fn, []string{"G", "7", "-1"}, "days", "must be greater than zero"},
+		{fn, []string{"G", "7", "", "name,salary"}, "fields", `unknown field "salary"`},
+		{variadic, []string{"1"}, "", ""},
+		{variadic, []string{"1", "Org1MSP", "Org2MSP", "Org3MSP"}, "", ""},
+		{variadic, []string{}, "", "Expecting (value, [adminmspid...])"},
+		{variadic, []string{"x", "Org1MSP"}, "value", "must be an integer"},
+	}
+	for _, tt := range tests {
+		err := tt.fn.check("f", tt.args)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("check(%q) = %v, want no error", tt.args, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("check(%q) = nil, want %q", tt.args, tt.want)
+			continue
+		}
+		kerr := err.(*kycError)
+		if kerr.Code != codeInvalidArgument || kerr.Arg != tt.arg || !strings.Contains(kerr.Message, tt.want) {
+			t.Errorf("check(%q) = %v, want %q on %q", tt.args, err, tt.want, tt.arg)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// HistoryEntry is one past value of a key, Value is null for the entry that deleted it
+type HistoryEntry struct {
+	TxID      string          `json:"txid"`
+	Timestamp string          `json:"timestamp"`
+	IsDelete  bool            `json:"isdelete"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// ============================================================================================================================
+// Read Customer History - every salted hash the customer under a card id has had. Private data keeps no history, so
+// this is the history of the public guarantee id record, one entry per change to the customer. Only the customer, the
+// holder of its record or an admin can read it, it tells whoever asks which guarantee id a card id has
+// ============================================================================================================================
+func (t *SimpleChaincode) readcustomerhistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//    0
+	// "cardid"
+	link, err := getCusGuaID(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	gua, err := getGuarantee(stub, link.GuaranteeID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := getCustomer(stub, link.GuaranteeID)
+	if err != nil {
+		return nil, err
+	}
+	err = assertCustodian(stub, gua, res)
+	if err != nil {
+		return nil, err
+	}
+	return keyHistory(stub, GuaranteeIDKey+link.GuaranteeID, "cardid")
+}
+
+// ============================================================================================================================
+// Read Guarantee History - every value a guarantee id record has had
+// ============================================================================================================================
+func (t *SimpleChaincode) readguaranteehistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//      0
+	// "guaranteeid"
+	return keyHistory(stub, GuaranteeIDKey+args[0], "guaranteeid")
+}
+
+// ============================================================================================================================
+// Read Broker History - every value a broker record has had, only the broker can read it, as with readbroker
+// ============================================================================================================================
+func (t *SimpleChaincode) readbrokerhistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0
+	// "brokeno"
+	brokeNo, _ := strconv.Atoi(args[0])
+	key := BrokerKey + strconv.Itoa(brokeNo)
+	brokerAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get state for broker "+strconv.Itoa(brokeNo))
+	}
+	if len(brokerAsBytes) == 0 {
+		return nil, notFound("brokeno", "This broker does not exist")
+	}
+	broker := Broker{}
+	json.Unmarshal(brokerAsBytes, &broker)
+	err = assertBroker(stub, broker)
+	if err != nil {
+		return nil, err
+	}
+	return keyHistory(stub, key, "brokeno")
+}
+
+// ============================================================================================================================
+// Read Consent History - every status the consent between a guarantee id and a broker has been through
+// ============================================================================================================================
+func (t *SimpleChaincode) readconsenthistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//      0            1
+	// "guaranteeid", "brokeno"
+	brokeNo, _ := strconv.Atoi(args[1])
+	key, err := stub.CreateCompositeKey(consentObjectType, []string{args[0], strconv.Itoa(brokeNo)})
+	if err != nil {
+		return nil, ledgerError(err, "Failed to create consent key")
+	}
+	return keyHistory(stub, key, "brokeno")
+}
+
+// ============================================================================================================================
+// keyHistory - the history of one key, oldest first. The peer does not promise an order so it is sorted here
+// ============================================================================================================================
+func keyHistory(stub shim.ChaincodeStubInterface, key, arg string) ([]byte, error) {
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get history")
+	}
+	defer resultsIterator.Close()
+
+	type timedEntry struct {
+		at    time.Time
+		entry HistoryEntry
+	}
+	var timed []timedEntry
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, ledgerError(err, "Failed to iterate history")
+		}
+		ts := modification.GetTimestamp()
+		at := time.Unix(ts.GetSeconds(), int64(ts.GetNanos())).UTC()
+
+		entry := HistoryEntry{}
+		entry.TxID = modification.GetTxId()
+		entry.Timestamp = at.Format(time.RFC3339Nano)
+		entry.IsDelete = modification.GetIsDelete()
+		if len(modification.GetValue()) > 0 {
+			entry.Value = modification.GetValue()
+		}
+		timed = append(timed, timedEntry{at, entry})
+	}
+	if len(timed) == 0 {
+		return nil, notFound(arg, "There is no history for this "+arg)
+	}
+	sort.SliceStable(timed, func(i, j int) bool { return timed[i].at.Before(timed[j].at) })
+
+	history := make([]HistoryEntry, len(timed))
+	for i := range timed {
+		history[i] = timed[i].entry
+	}
+	jsonAsBytes, _ := json.Marshal(history)
+	return jsonAsBytes, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReadCustomerHistory(t *testing.T) {
+	s, gid := newWorld(t)
+	s.withCustomer(sampleInput(sampleCardID, "Somsak"))
+	s.must(agent, "update_customer")
+
+	s.fails(codePermissionDenied, stranger, "readcustomerhistory", sampleCardID)
+	s.fails(codePermissionDenied, broker, "readcustomerhistory", sampleCardID)
+	for _, id := range []testIdentity{customer, agent, admin} {
+		var history []HistoryEntry
+		json.Unmarshal(s.must(id, "readcustomerhistory", sampleCardID), &history)
+		if len(history) != 2 {
+			t.Fatalf("readcustomerhistory = %d entries, want 2", len(history))
+		}
+		gua := GuaranteeID{}
+		json.Unmarshal(history[1].Value, &gua)
+		if gua.GuaranteeID != gid || len(gua.CustomerHash) == 0 {
+			t.Errorf("readcustomerhistory last value = %s", history[1].Value)
+		}
+	}
+}
+
+func TestReadBrokerHistory(t *testing.T) {
+	s, _ := newWorld(t)
+	s.fails(codePermissionDenied, stranger, "readbrokerhistory", sampleBroker)
+	s.fails(codeNotFound, broker, "readbrokerhistory", "8")
+
+	var history []HistoryEntry
+	json.Unmarshal(s.must(broker, "readbrokerhistory", sampleBroker), &history)
+	if len(history) != 1 || history[0].IsDelete {
+		t.Errorf("readbrokerhistory = %+v", history)
+	}
+}
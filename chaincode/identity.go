@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// cardIDAttr is the certificate attribute the CA puts on a customer's enrollment certificate, the customer's card id
+const cardIDAttr = "kyc.cardid"
+
+// roleAttr is the certificate attribute that makes an identity an admin when it is set to adminRole, see isAdmin
+const (
+	roleAttr  = "kyc.role"
+	adminRole = "admin"
+)
+
+// ============================================================================================================================
+// assertCustomer - the submitter has to be the customer the guarantee id was issued to. Any org's CA can put a card id
+// in a certificate, so it only counts in one from the MSP the customer is enrolled with
+// ============================================================================================================================
+func assertCustomer(stub shim.ChaincodeStubInterface, gua GuaranteeID) error {
+	cardid, found, err := cid.GetAttributeValue(stub, cardIDAttr)
+	if err != nil {
+		return ledgerError(err, "Failed to read the submitter's identity")
+	}
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return ledgerError(err, "Failed to read the submitter's MSP id")
+	}
+	if !found || cardid != gua.CustomerID || mspid != gua.CustomerMSPID {
+		return permissionDenied("guaranteeid", "Only the customer can see or change this customer's consent")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// assertCustodian - the submitter has to be the customer, the identity holding the customer's record, or an admin
+// ============================================================================================================================
+func assertCustodian(stub shim.ChaincodeStubInterface, gua GuaranteeID, res Customer) error {
+	if assertCustomer(stub, gua) == nil {
+		return nil
+	}
+	subject, mspid, err := submitter(stub)
+	if err != nil {
+		return err
+	}
+	if subject == res.Creator && mspid == res.CreatorMSPID {
+		return nil
+	}
+	admin, err := isAdmin(stub)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return permissionDenied("", "Only the customer, the holder of its record or an admin can do this")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// submitter - the certificate subject and MSP id of whoever signed the proposal
+// ============================================================================================================================
+func submitter(stub shim.ChaincodeStubInterface) (string, string, error) {
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return "", "", ledgerError(err, "Failed to read the submitter's certificate")
+	}
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", "", ledgerError(err, "Failed to read the submitter's MSP id")
+	}
+	return cert.Subject.String(), mspid, nil
+}
+
+// ============================================================================================================================
+// assertBroker - the submitter has to be the identity the broker was registered with
+// ============================================================================================================================
+func assertBroker(stub shim.ChaincodeStubInterface, broker Broker) error {
+	subject, mspid, err := submitter(stub)
+	if err != nil {
+		return err
+	}
+	if subject != broker.Subject || mspid != broker.MSPID {
+		return permissionDenied("brokeno", "Only the identity this broker was registered with can act as it")
+	}
+	return nil
+}
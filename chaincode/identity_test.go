@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAssertCustomerNeedsTheCustomersMSP(t *testing.T) {
+	s, gid := newWorld(t)
+	req := AccessRequest{}
+	json.Unmarshal(s.must(broker, "requestPermission", gid, sampleBroker, "name", "account opening"), &req)
+
+	//the same card id attribute from another org's CA
+	impostor := testIdentity{name: "somchai", mspid: "Org2MSP", attrs: map[string]string{cardIDAttr: sampleCardID}}
+	s.fails(codePermissionDenied, impostor, "readcustomerconsents", sampleCardID)
+	s.fails(codePermissionDenied, impostor, "readrequests", gid)
+	s.fails(codePermissionDenied, impostor, "customerallow", req.RequestID)
+	s.fails(codePermissionDenied, impostor, "rejectBroker", req.RequestID)
+	s.fails(codePermissionDenied, impostor, "readcustomer", sampleCardID)
+	s.fails(codePermissionDenied, agent, "customerallow", req.RequestID) //holding the record is not being the customer
+
+	s.must(customer, "readcustomerconsents", sampleCardID)
+	s.must(customer, "customerallow", req.RequestID)
+	s.fails(codePermissionDenied, impostor, "cancelAllow", gid, sampleBroker)
+	s.must(customer, "cancelAllow", gid, sampleBroker)
+}
+
+func TestAssertBroker(t *testing.T) {
+	s, gid := newWorld(t)
+	for _, brokeNo := range []string{sampleBroker, "07", "+7"} {
+		res := s.invoke(stranger, "newbroke", "Not Seven", brokeNo)
+		if got := responseCode(res); got != codeAlreadyExists && got != codeInvalidArgument {
+			t.Errorf("newbroke(%s) by another identity = %s", brokeNo, res.Message)
+		}
+	}
+	s.fails(codePermissionDenied, stranger, "readbroker", sampleBroker)
+	s.fails(codePermissionDenied, stranger, "requestPermission", gid, sampleBroker, "name", "account opening")
+	s.fails(codePermissionDenied, broker, "readcustomerbroker", gid, sampleBroker) //the right broker, but no consent yet
+
+	detail := brokerDetail{}
+	json.Unmarshal(s.must(broker, "readbroker", sampleBroker), &detail)
+	if detail.Name != "Broker Seven" || detail.MSPID != broker.mspid {
+		t.Errorf("readbroker = %+v", detail)
+	}
+}
+
+func TestIsAdminNeedsTheRole(t *testing.T) {
+	s, gid := newWorld(t)
+	member := testIdentity{name: "clerk", mspid: admin.mspid}
+	elsewhere := testIdentity{name: "admin", mspid: "Org2MSP", attrs: map[string]string{roleAttr: adminRole}}
+	notAdmin := testIdentity{name: "admin", mspid: admin.mspid, attrs: map[string]string{roleAttr: "clerk"}}
+
+	for _, id := range []testIdentity{member, elsewhere, notAdmin} {
+		s.fails(codePermissionDenied, id, "migraterequests")
+		s.fails(codePermissionDenied, id, "set_user", gid, "CN=other", "Org1MSP")
+		s.fails(codePermissionDenied, id, "readcustomer", sampleCardID)
+	}
+	s.must(admin, "migraterequests")
+	s.must(admin, "readcustomer", sampleCardID)
+	s.must(admin, "set_user", gid, "CN=other", "Org1MSP")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// maxPageSize caps how many records one list call returns
+const maxPageSize = 100
+
+// the arrays the list indexes replaced, the customer one held card ids on the public ledger
+var legacyCustomerIndexStr = "_customerindex"
+var legacyBrokerIndexStr = "_brokerindex"
+
+// indexMigration is what migrateindexes returns, how many entries it put in each list index
+type indexMigration struct {
+	Customers int `json:"customers"`
+	Brokers   int `json:"brokers"`
+}
+
+// Page is what the list queries return, pass Bookmark back to get the next page. It is empty after the last page
+type Page struct {
+	Records  []json.RawMessage `json:"records"`
+	Count    int32             `json:"count"`
+	Bookmark string            `json:"bookmark"`
+}
+
+// ============================================================================================================================
+// putIndexEntry - add a key to one of the list indexes, the entry is all key and no value
+// ============================================================================================================================
+func putIndexEntry(stub shim.ChaincodeStubInterface, objectType, id string) error {
+	indexKey, err := stub.CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return ledgerError(err, "Failed to create index key")
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return ledgerError(err, "Failed to put index entry")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// delIndexEntry - remove a key from one of the list indexes
+// ============================================================================================================================
+func delIndexEntry(stub shim.ChaincodeStubInterface, objectType, id string) error {
+	indexKey, err := stub.CreateCompositeKey(objectType, []string{id})
+	if err != nil {
+		return ledgerError(err, "Failed to create index key")
+	}
+	err = stub.DelState(indexKey)
+	if err != nil {
+		return ledgerError(err, "Failed to delete index entry")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// List Customers - one page of public guarantee id records, in guarantee id order. The customers themselves are private
+// ============================================================================================================================
+func (t *SimpleChaincode) listCustomers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0          1
+	// "pagesize", "bookmark"
+	return listPage(stub, customerIndexStr, GuaranteeIDKey, args)
+}
+
+// ============================================================================================================================
+// List Brokers - one page of full broker records, in broker number order
+// ============================================================================================================================
+func (t *SimpleChaincode) listBrokers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//     0          1
+	// "pagesize", "bookmark"
+	return listPage(stub, brokerIndexStr, BrokerKey, args)
+}
+
+// ============================================================================================================================
+// Migrate Indexes - put every guarantee id and broker record already on the ledger in the list indexes, so customers
+// and brokers made before them are listed too, then delete the old index arrays. Only an admin can do it, and running
+// it again changes nothing
+// ============================================================================================================================
+func (t *SimpleChaincode) migrateindexes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	admin, err := isAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return nil, permissionDenied("", "Only an admin can migrate the indexes")
+	}
+
+	migration := indexMigration{}
+	migration.Customers, err = backfillIndex(stub, customerIndexStr, GuaranteeIDKey)
+	if err != nil {
+		return nil, err
+	}
+	migration.Brokers, err = backfillIndex(stub, brokerIndexStr, BrokerKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{legacyCustomerIndexStr, legacyBrokerIndexStr} {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, ledgerError(err, "Failed to delete "+key)
+		}
+	}
+
+	jsonAsBytes, _ := json.Marshal(migration)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// backfillIndex - add an index entry for every record under a key prefix, by range scan
+// ============================================================================================================================
+func backfillIndex(stub shim.ChaincodeStubInterface, objectType, recordKey string) (int, error) {
+	resultsIterator, err := stub.GetStateByRange(recordKey, recordKey+"~") //ids are letters and digits, all below ~
+	if err != nil {
+		return 0, ledgerError(err, "Failed to get records for "+objectType)
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return 0, ledgerError(err, "Failed to iterate records for "+objectType)
+		}
+		err = putIndexEntry(stub, objectType, strings.TrimPrefix(kv.Key, recordKey))
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ============================================================================================================================
+// listPage - page through a list index and fetch the record each entry points at
+// ============================================================================================================================
+func listPage(stub shim.ChaincodeStubInterface, objectType, recordKey string, args []string) ([]byte, error) {
+	pageSize, _ := strconv.Atoi(args[0])
+	bookmark := args[1]
+
+	resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(objectType, []string{}, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get page of "+objectType)
+	}
+	defer resultsIterator.Close()
+
+	page := Page{}
+	page.Records = []json.RawMessage{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, ledgerError(err, "Failed to iterate "+objectType)
+		}
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, ledgerError(err, "Failed to split index key")
+		}
+		valAsBytes, err := stub.GetState(recordKey + keyParts[0])
+		if err != nil {
+			return nil, ledgerError(err, "Failed to get state for "+keyParts[0])
+		}
+		if len(valAsBytes) == 0 {
+			continue //an index entry without a record behind it is skipped, not fatal
+		}
+		page.Records = append(page.Records, valAsBytes)
+	}
+	page.Count = int32(len(page.Records))
+	page.Bookmark = metadata.Bookmark
+
+	jsonAsBytes, _ := json.Marshal(page)
+	return jsonAsBytes, nil
+}
+
+// pageSize - validation for the page size argument of the list queries
+func pageSize(arg string) error {
+	n, _ := strconv.Atoi(arg)
+	if n <= 0 || n > maxPageSize {
+		return errors.New("must be between 1 and " + strconv.Itoa(maxPageSize))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestListCustomersPages(t *testing.T) {
+	s, gid := newWorld(t)
+	want := []string{gid, s.newCustomer("1101700203468", "Somsri"), s.newCustomer("1101700203476", "Somsak")}
+	sort.Strings(want)
+
+	var got []string
+	bookmark := ""
+	for pages := 0; pages == 0 || len(bookmark) > 0; pages++ {
+		if pages > len(want) {
+			t.Fatalf("listCustomers is still paging after %d pages", pages)
+		}
+		page := Page{}
+		json.Unmarshal(s.must(stranger, "listCustomers", "2", bookmark), &page)
+		if page.Count != int32(len(page.Records)) || page.Count > 2 {
+			t.Errorf("page %d = %d records, count %d", pages, len(page.Records), page.Count)
+		}
+		for _, record := range page.Records {
+			if strings.Contains(string(record), sampleCardID) {
+				t.Errorf("listCustomers record %s holds a card id", record)
+			}
+			gua := GuaranteeID{}
+			json.Unmarshal(record, &gua)
+			got = append(got, gua.GuaranteeID)
+		}
+		bookmark = page.Bookmark
+	}
+	if len(got) != len(want) {
+		t.Fatalf("listCustomers = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("listCustomers = %q, want %q in guarantee id order", got, want)
+		}
+	}
+
+	s.fails(codeInvalidArgument, stranger, "listCustomers", "0")
+	s.fails(codeInvalidArgument, stranger, "listBrokers", "101")
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// customerCollection is the private data collection customer records live in, see collections_config.json.
+// The public ledger only keeps a salted hash of each record on its guarantee id
+var customerCollection = "collectionCustomers"
+
+// customerNameIndex is the private composite key from a customer's name to their guarantee id, names need not be
+// unique. The name goes in as its privateKey, like the card id in a CusGuaIDKey
+var customerNameIndex = "name~guaranteeid"
+
+// cardIDSecretKey is the private key of the secret privateKey is keyed with, Init stores it once
+var cardIDSecretKey = "_cardidsecret"
+
+// cardIDSecretField is the transient map entry Init takes that secret from
+var cardIDSecretField = "cardidsecret"
+
+// saltField is the transient map entry newcustomer takes the hashing salt from
+var saltField = "salt"
+
+// gidSecretField is the transient map entry newcustomer keys the guarantee id HMAC with
+var gidSecretField = "gidsecret"
+
+// customerField is the transient map entry newcustomer and update_customer take the customer's details from, as json
+var customerField = "customer"
+
+// customerInput is what goes in the customerField entry, the customer's age comes from DOB
+type customerInput struct {
+	Name         string  `json:"name"`
+	CardID       string  `json:"cardid"`
+	TelNo        string  `json:"telno"`
+	DOB          string  `json:"dob"`
+	Nationality  string  `json:"nationality"`
+	Occupation   string  `json:"occupation"`
+	Address      Address `json:"address"`
+	IDType       string  `json:"idtype"`
+	IDIssueDate  string  `json:"idissuedate"`
+	IDExpiryDate string  `json:"idexpirydate"`
+}
+
+// profile - copy the profile fields onto a customer record
+func (input customerInput) profile(res *Customer) {
+	res.DOB = input.DOB
+	res.Age = 0 //read from DOB from now on
+	res.Nationality = input.Nationality
+	res.Address = input.Address
+	res.IDType = input.IDType
+	res.IDIssueDate = input.IDIssueDate
+	res.IDExpiryDate = input.IDExpiryDate
+}
+
+// CusGuaID links a card id to its guarantee id, private, with the salt the customer's public hash is made with
+type CusGuaID struct {
+	CardID      string `json:"cardid"`
+	GuaranteeID string `json:"guaranteeid"`
+	Salt        string `json:"salt"` //hex
+}
+
+// legacyCustomer is a customer as it was kept on the public ledger before customerCollection, under both its card id
+// and its name
+type legacyCustomer struct {
+	Name             string          `json:"name"`
+	CardID           string          `json:"cardid"`
+	TelNo            string          `json:"telno"`
+	Age              int             `json:"age"`
+	Occupation       string          `json:"occupation"`
+	Creator          string          `json:"creator"`
+	PreviousCreators []CreatorChange `json:"previouscreators"`
+}
+
+// customerMigration is what migratecustomers returns
+type customerMigration struct {
+	Customers int      `json:"customers"` //moved into customerCollection
+	Deleted   int      `json:"deleted"`   //public customer records deleted
+	Unlinked  []string `json:"unlinked"`  //guarantee ids left without a customer, see migratecustomers
+}
+
+// ============================================================================================================================
+// transientSalt - the salt passed in the transient map, so it never lands in the transaction
+// ============================================================================================================================
+func transientSalt(stub shim.ChaincodeStubInterface) ([]byte, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get transient map")
+	}
+	salt := transient[saltField]
+	if len(salt) == 0 {
+		return nil, invalidArgument(saltField, "A salt has to be passed in the transient map under "+saltField)
+	}
+	return salt, nil
+}
+
+// ============================================================================================================================
+// newGuaranteeID - HMAC-SHA256 of the transaction id, keyed with the secret passed in the transient map. Nothing about
+// the customer goes into it, so it can not be guessed from their details, and it is made once and never re-derived
+// ============================================================================================================================
+func newGuaranteeID(stub shim.ChaincodeStubInterface) (string, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return "", ledgerError(err, "Failed to get transient map")
+	}
+	secret := transient[gidSecretField]
+	if len(secret) == 0 {
+		return "", invalidArgument(gidSecretField, "A guarantee id secret has to be passed in the transient map under "+gidSecretField)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(stub.GetTxID()))
+	gid := strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))
+
+	//a live or an erased customer may already hold it
+	for _, key := range []string{GuaranteeIDKey + gid, ErasedKey + gid} {
+		valAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return "", ledgerError(err, "Failed to get state for "+gid)
+		}
+		if len(valAsBytes) > 0 {
+			return "", alreadyExists("guaranteeid", "This guarantee id is already taken, retry with a new transaction")
+		}
+	}
+	return gid, nil
+}
+
+// ============================================================================================================================
+// putCardIDSecret - store the secret privateKey is keyed with, from the transient map. Keys already made with a secret
+// would be lost if it changed, so once it is set it stays
+// ============================================================================================================================
+func putCardIDSecret(stub shim.ChaincodeStubInterface) error {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return ledgerError(err, "Failed to get transient map")
+	}
+	secret := transient[cardIDSecretField]
+	if len(secret) == 0 {
+		return nil //an upgrade keeps the one it has
+	}
+	secretAsBytes, err := stub.GetPrivateData(customerCollection, cardIDSecretKey)
+	if err != nil {
+		return ledgerError(err, "Failed to get the card id secret")
+	}
+	if len(secretAsBytes) > 0 {
+		return alreadyExists(cardIDSecretField, "The card id secret is already set and can not be changed")
+	}
+	err = stub.PutPrivateData(customerCollection, cardIDSecretKey, secret)
+	if err != nil {
+		return ledgerError(err, "Failed to put the card id secret")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// privateKey - what a card id or a name goes into a private key as, HMAC-SHA256 keyed with the card id secret. The
+// peers publish a hash of every private key, so a plain card id in one could be found by trying them all
+// ============================================================================================================================
+func privateKey(stub shim.ChaincodeStubInterface, value string) (string, error) {
+	secret, err := stub.GetPrivateData(customerCollection, cardIDSecretKey)
+	if err != nil {
+		return "", ledgerError(err, "Failed to get the card id secret")
+	}
+	if len(secret) == 0 {
+		return "", notFound(cardIDSecretField, "The card id secret has not been set, pass it to Init in the transient map under "+cardIDSecretField)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+// ============================================================================================================================
+// transientCustomer - the customer's details from the transient map, checked the way arguments are
+// ============================================================================================================================
+func transientCustomer(stub shim.ChaincodeStubInterface) (customerInput, error) {
+	input := customerInput{}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return input, ledgerError(err, "Failed to get transient map")
+	}
+	inputAsBytes := transient[customerField]
+	if len(inputAsBytes) == 0 {
+		return input, invalidArgument(customerField, "The customer has to be passed in the transient map under "+customerField)
+	}
+	err = json.Unmarshal(inputAsBytes, &input)
+	if err != nil {
+		return input, invalidArgument(customerField, "The "+customerField+" entry in the transient map is not valid json")
+	}
+
+	required := []struct{ name, value string }{
+		{"name", input.Name},
+		{"cardid", input.CardID},
+		{"telno", input.TelNo},
+		{"dob", input.DOB},
+		{"nationality", input.Nationality},
+		{"occupation", input.Occupation},
+		{"idtype", input.IDType},
+		{"idissuedate", input.IDIssueDate},
+		{"idexpirydate", input.IDExpiryDate},
+	}
+	for _, field := range required {
+		if len(field.value) == 0 {
+			return input, invalidArgument(field.name, customerField+"."+field.name+" must be a non-empty string")
+		}
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return input, err
+	}
+	checks := []error{
+		validCardID(input.IDType, input.CardID),
+		validPhone(input.TelNo),
+		validDOB(input.DOB, now),
+		validCountry("nationality", input.Nationality),
+		validIDDates(input.IDIssueDate, input.IDExpiryDate, now),
+	}
+	if len(input.Address.Country) > 0 {
+		checks = append(checks, validCountry("address.country", input.Address.Country))
+	}
+	for _, err := range checks {
+		if err != nil {
+			return input, err
+		}
+	}
+	return input, nil
+}
+
+// customerHash - the salted hash of a customer record that stands in for it on the public ledger
+func customerHash(salt, customerAsBytes []byte) string {
+	sha256AsByte := sha256.Sum256(append(append([]byte{}, salt...), customerAsBytes...))
+	return strings.ToUpper(hex.EncodeToString(sha256AsByte[:]))
+}
+
+// ============================================================================================================================
+// getGuarantee - the public guarantee id record with the card id filled in from the private collection
+// ============================================================================================================================
+func getGuarantee(stub shim.ChaincodeStubInterface, gid string) (GuaranteeID, error) {
+	gua := GuaranteeID{}
+	gidAsBytes, err := stub.GetState(GuaranteeIDKey + gid)
+	if err != nil {
+		return gua, ledgerError(err, "Failed to get state for "+gid)
+	}
+	if len(gidAsBytes) == 0 {
+		return gua, notFound("guaranteeid", "This guarantee id does not exist")
+	}
+	json.Unmarshal(gidAsBytes, &gua)
+
+	linkAsBytes, err := stub.GetPrivateData(customerCollection, GuaranteeIDKey+gid)
+	if err != nil {
+		return gua, ledgerError(err, "Failed to get private data for "+gid)
+	}
+	link := GuaranteeID{}
+	json.Unmarshal(linkAsBytes, &link)
+	gua.CustomerID = link.CustomerID
+	gua.CustomerMSPID = link.CustomerMSPID
+	return gua, nil
+}
+
+// ============================================================================================================================
+// getCusGuaID - the private link from a card id to its guarantee id
+// ============================================================================================================================
+func getCusGuaID(stub shim.ChaincodeStubInterface, cardid string) (CusGuaID, error) {
+	link := CusGuaID{}
+	key, err := privateKey(stub, cardid)
+	if err != nil {
+		return link, err
+	}
+	linkAsBytes, err := stub.GetPrivateData(customerCollection, CusGuaIDKey+key)
+	if err != nil {
+		return link, ledgerError(err, "Failed to get the customer's guarantee id")
+	}
+	if len(linkAsBytes) == 0 {
+		return link, notFound("cardid", "This customer does not exist")
+	}
+	json.Unmarshal(linkAsBytes, &link)
+	return link, nil
+}
+
+// ============================================================================================================================
+// putCusGuaID - write the private link from a card id to its guarantee id
+// ============================================================================================================================
+func putCusGuaID(stub shim.ChaincodeStubInterface, link CusGuaID) error {
+	key, err := privateKey(stub, link.CardID)
+	if err != nil {
+		return err
+	}
+	linkAsBytes, _ := json.Marshal(link)
+	err = stub.PutPrivateData(customerCollection, CusGuaIDKey+key, linkAsBytes)
+	if err != nil {
+		return ledgerError(err, "Failed to put the customer's guarantee id")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// delCusGuaID - remove the private link from a card id to its guarantee id
+// ============================================================================================================================
+func delCusGuaID(stub shim.ChaincodeStubInterface, cardid string) error {
+	key, err := privateKey(stub, cardid)
+	if err != nil {
+		return err
+	}
+	err = stub.DelPrivateData(customerCollection, CusGuaIDKey+key)
+	if err != nil {
+		return ledgerError(err, "Failed to delete the customer's guarantee id")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// getCustomer - the private customer record under a guarantee id
+// ============================================================================================================================
+func getCustomer(stub shim.ChaincodeStubInterface, gid string) (Customer, error) {
+	res := Customer{}
+	customerAsBytes, err := stub.GetPrivateData(customerCollection, customerKey+gid)
+	if err != nil {
+		return res, ledgerError(err, "Failed to get private data for "+gid)
+	}
+	if len(customerAsBytes) == 0 {
+		return res, notFound("guaranteeid", "This customer does not exist")
+	}
+	json.Unmarshal(customerAsBytes, &res)
+	return res, nil
+}
+
+// ============================================================================================================================
+// putCustomer - write an existing customer record back, through the private link its card id already has
+// ============================================================================================================================
+func putCustomer(stub shim.ChaincodeStubInterface, res Customer) error {
+	link, err := getCusGuaID(stub, res.CardID)
+	if err != nil {
+		return err
+	}
+	return writeCustomer(stub, res, link)
+}
+
+// ============================================================================================================================
+// writeCustomer - write a customer record to the private collection under its guarantee id, and refresh the salted hash
+// on its public guarantee id record. newcustomer passes the link it has just made, private reads do not see this
+// transaction's writes
+// ============================================================================================================================
+func writeCustomer(stub shim.ChaincodeStubInterface, res Customer, link CusGuaID) error {
+	salt, _ := hex.DecodeString(link.Salt)
+
+	str, _ := json.Marshal(res)
+	err := stub.PutPrivateData(customerCollection, customerKey+link.GuaranteeID, str)
+	if err != nil {
+		return ledgerError(err, "Failed to put customer")
+	}
+
+	gua := GuaranteeID{}
+	gua.GuaranteeID = link.GuaranteeID
+	gua.CustomerHash = customerHash(salt, str)
+	gidAsBytes, _ := json.Marshal(gua)
+	err = stub.PutState(GuaranteeIDKey+gua.GuaranteeID, gidAsBytes)
+	if err != nil {
+		return ledgerError(err, "Failed to put guarantee id")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// nameEntryKey - the private name index key of a customer
+// ============================================================================================================================
+func nameEntryKey(stub shim.ChaincodeStubInterface, name, gid string) (string, error) {
+	nameKey, err := privateKey(stub, name)
+	if err != nil {
+		return "", err
+	}
+	indexKey, err := stub.CreateCompositeKey(customerNameIndex, []string{nameKey, gid})
+	if err != nil {
+		return "", ledgerError(err, "Failed to create name index key")
+	}
+	return indexKey, nil
+}
+
+// ============================================================================================================================
+// putNameEntry - add a customer to the private name index
+// ============================================================================================================================
+func putNameEntry(stub shim.ChaincodeStubInterface, name, gid string) error {
+	indexKey, err := nameEntryKey(stub, name, gid)
+	if err != nil {
+		return err
+	}
+	err = stub.PutPrivateData(customerCollection, indexKey, []byte{0x00})
+	if err != nil {
+		return ledgerError(err, "Failed to put name index entry")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// delNameEntry - remove a customer from the private name index
+// ============================================================================================================================
+func delNameEntry(stub shim.ChaincodeStubInterface, name, gid string) error {
+	indexKey, err := nameEntryKey(stub, name, gid)
+	if err != nil {
+		return err
+	}
+	err = stub.DelPrivateData(customerCollection, indexKey)
+	if err != nil {
+		return ledgerError(err, "Failed to delete name index entry")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Migrate Customers - move the customers kept on the public ledger before customerCollection into it, the way
+// newcustomer would have written them, and delete the public copies. Each keeps its guarantee id, so the consents on it
+// stand. A guarantee id whose customer is missing, or whose card id already has another guarantee id, is cut down to
+// the guarantee id alone and listed as unlinked. It needs a salt in the transient map, each customer is hashed with
+// its own salt made from it. Only an admin can do it, after migrateconsents, and running it again finds nothing left
+// ============================================================================================================================
+func (t *SimpleChaincode) migratecustomers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//        0
+	// "customermspid"
+	customerMSPID := args[0] //the old records do not say who enrolled the customers
+
+	admin, err := isAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return nil, permissionDenied("", "Only an admin can migrate customers")
+	}
+	salt, err := transientSalt(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := stub.GetStateByRange(GuaranteeIDKey, GuaranteeIDKey+"~") //ids are letters and digits, all below ~
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get guarantee ids")
+	}
+	defer resultsIterator.Close()
+
+	migration := customerMigration{}
+	migration.Unlinked = []string{}
+	linked := map[string]bool{} //card ids moved by this transaction, private reads do not see them
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, ledgerError(err, "Failed to iterate guarantee ids")
+		}
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(kv.Value, &fields)
+		for _, field := range legacyGrantFields {
+			if _, ok := fields[field]; ok {
+				return nil, invalidArgument("", "Run migrateconsents first, "+kv.Key+" still lists its brokers")
+			}
+		}
+		gua := GuaranteeID{}
+		json.Unmarshal(kv.Value, &gua)
+		if len(gua.CustomerID) == 0 {
+			continue //already private
+		}
+
+		old := legacyCustomer{}
+		oldAsBytes, err := stub.GetState(customerKey + gua.CustomerID)
+		if err != nil {
+			return nil, ledgerError(err, "Failed to get customer for "+gua.GuaranteeID)
+		}
+		json.Unmarshal(oldAsBytes, &old)
+		_, err = getCusGuaID(stub, gua.CustomerID)
+		taken := err == nil || linked[gua.CustomerID]
+		if kerr, ok := err.(*kycError); err != nil && (!ok || kerr.Code != codeNotFound || kerr.Arg != "cardid") {
+			return nil, err
+		}
+		if len(oldAsBytes) == 0 || old.CardID != gua.CustomerID || taken {
+			//no card id on the public record either way
+			jsonAsBytes, _ := json.Marshal(GuaranteeID{GuaranteeID: gua.GuaranteeID})
+			err = stub.PutState(kv.Key, jsonAsBytes)
+			if err != nil {
+				return nil, ledgerError(err, "Failed to put guarantee id")
+			}
+			migration.Unlinked = append(migration.Unlinked, gua.GuaranteeID)
+			continue
+		}
+		linked[old.CardID] = true
+
+		res := Customer{}
+		res.Name = old.Name
+		res.CardID = old.CardID
+		res.TelNo = old.TelNo
+		res.Age = old.Age //no date of birth to work it out from
+		res.Occupation = old.Occupation
+		res.Creator = old.Creator
+		res.PreviousCreators = old.PreviousCreators
+
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(gua.GuaranteeID))
+		link := CusGuaID{}
+		link.CardID = res.CardID
+		link.GuaranteeID = gua.GuaranteeID
+		link.Salt = hex.EncodeToString(mac.Sum(nil))
+
+		str, _ := json.Marshal(GuaranteeID{GuaranteeID: gua.GuaranteeID, CustomerID: res.CardID, CustomerMSPID: customerMSPID})
+		err = stub.PutPrivateData(customerCollection, GuaranteeIDKey+gua.GuaranteeID, str)
+		if err != nil {
+			return nil, ledgerError(err, "Failed to put private data for "+gua.GuaranteeID)
+		}
+		err = putCusGuaID(stub, link)
+		if err != nil {
+			return nil, err
+		}
+		err = writeCustomer(stub, res, link)
+		if err != nil {
+			return nil, err
+		}
+		err = putNameEntry(stub, res.Name, gua.GuaranteeID)
+		if err != nil {
+			return nil, err
+		}
+		migration.Customers++
+	}
+
+	//every public customer record is a copy left behind, under a card id or a name
+	migration.Deleted, err = delLegacyCustomers(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonAsBytes, _ := json.Marshal(migration)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// delLegacyCustomers - delete every customer record on the public ledger, by range scan
+// ============================================================================================================================
+func delLegacyCustomers(stub shim.ChaincodeStubInterface) (int, error) {
+	resultsIterator, err := stub.GetStateByRange(customerKey, customerKey+string(utf8.MaxRune)) //names can be anything
+	if err != nil {
+		return 0, ledgerError(err, "Failed to get public customers")
+	}
+	defer resultsIterator.Close()
+
+	var keys []string
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return 0, ledgerError(err, "Failed to iterate public customers")
+		}
+		keys = append(keys, kv.Key)
+	}
+	for _, key := range keys {
+		err = stub.DelState(key)
+		if err != nil {
+			return 0, ledgerError(err, "Failed to delete a public customer")
+		}
+	}
+	return len(keys), nil
+}
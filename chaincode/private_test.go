@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrivateKeysHideCardIDAndName(t *testing.T) {
+	s, gid := newWorld(t)
+
+	keys := sortedKeys(s.PvtState[customerCollection])
+	for _, key := range keys {
+		if strings.Contains(key, sampleCardID) || strings.Contains(key, sampleName) {
+			t.Errorf("private key %q holds the card id or the name", key)
+		}
+	}
+	if _, ok := s.PvtState[customerCollection][customerKey+gid]; !ok {
+		t.Errorf("no customer record under %s in %v", customerKey+gid, keys)
+	}
+
+	res := Customer{}
+	json.Unmarshal(s.must(customer, "readcustomer", sampleCardID), &res)
+	if res.CardID != sampleCardID || res.Name != sampleName {
+		t.Errorf("readcustomer = %+v", res)
+	}
+	var named []Customer
+	json.Unmarshal(s.must(agent, "readcustomername", sampleName), &named)
+	if len(named) != 1 || named[0].CardID != sampleCardID {
+		t.Errorf("readcustomername = %+v", named)
+	}
+}
+
+func TestNewCustomerTwice(t *testing.T) {
+	s, _ := newWorld(t)
+	s.withCustomer(sampleInput(sampleCardID, "Someone Else"))
+	s.fails(codeAlreadyExists, agent, "newcustomer")
+}
+
+func TestRenameMovesNameEntry(t *testing.T) {
+	s, _ := newWorld(t)
+	s.withCustomer(sampleInput(sampleCardID, "Somsak"))
+	s.must(agent, "update_customer")
+
+	var named []Customer
+	json.Unmarshal(s.must(agent, "readcustomername", sampleName), &named)
+	if len(named) != 0 {
+		t.Errorf("the old name still finds %+v", named)
+	}
+	json.Unmarshal(s.must(agent, "readcustomername", "Somsak"), &named)
+	if len(named) != 1 {
+		t.Errorf("the new name finds %+v", named)
+	}
+}
+
+func TestDeleteCustomerRemovesPrivateData(t *testing.T) {
+	s, gid := newWorld(t)
+	s.must(agent, "delete", gid)
+
+	for _, key := range sortedKeys(s.PvtState[customerCollection]) {
+		if key != cardIDSecretKey {
+			t.Errorf("private key %q is left after delete", key)
+		}
+	}
+	s.fails(codeNotFound, agent, "readcustomer", sampleCardID)
+}
+
+func TestCardIDSecret(t *testing.T) {
+	s := newTestStub(t)
+	s.must(admin, "init", "99", admin.mspid)
+	s.withCustomer(sampleInput(sampleCardID, sampleName))
+	s.fails(codeNotFound, agent, "newcustomer")
+
+	s.transient = map[string][]byte{cardIDSecretField: []byte("one")}
+	s.must(admin, "init", "99", admin.mspid)
+	s.transient = map[string][]byte{cardIDSecretField: []byte("two")}
+	s.fails(codeAlreadyExists, admin, "init", "99", admin.mspid)
+	s.must(admin, "init", "99", admin.mspid) //an upgrade without one keeps it
+	if got := string(s.PvtState[customerCollection][cardIDSecretKey]); got != "one" {
+		t.Errorf("card id secret = %q, want one", got)
+	}
+}
+
+func TestMigrateCustomers(t *testing.T) {
+	s, _ := newWorld(t)
+	old := `{"name":"Somsri","cardid":"1234567890121","telno":"0898765432","age":41,"occupation":"teacher","address":"","creator":"agent1"}`
+	s.seed(customerKey+"1234567890121", old)
+	s.seed(customerKey+"Somsri", old)
+	s.seed(GuaranteeIDKey+"OLD1", `{"guaranteeid":"OLD1","customerid":"1234567890121","allowbroke":[7],"pendingbroke":null}`)
+	s.seed(GuaranteeIDKey+"OLD2", `{"guaranteeid":"OLD2","customerid":"1234567890121"}`)
+	s.seed(GuaranteeIDKey+"OLD3", `{"guaranteeid":"OLD3","customerid":"1111111111119"}`)
+
+	s.transient = map[string][]byte{saltField: []byte("salt")}
+	s.fails(codeInvalidArgument, admin, "migratecustomers", "Org1MSP") //the grants have to move first
+	s.must(admin, "migrateconsents")
+	s.transient = map[string][]byte{saltField: []byte("salt")}
+	s.fails(codePermissionDenied, agent, "migratecustomers", "Org1MSP")
+	s.fails(codeInvalidArgument, admin, "migratecustomers", "Org1MSP") //no salt
+
+	s.transient = map[string][]byte{saltField: []byte("salt")}
+	migration := customerMigration{}
+	json.Unmarshal(s.must(admin, "migratecustomers", "Org1MSP"), &migration)
+	if migration.Customers != 1 || migration.Deleted != 2 || strings.Join(migration.Unlinked, ",") != "OLD2,OLD3" {
+		t.Errorf("migratecustomers = %+v", migration)
+	}
+	for key, value := range s.State {
+		if strings.HasPrefix(key, customerKey) || strings.Contains(string(value), "1234567890121") {
+			t.Errorf("%s is still public: %s", key, value)
+		}
+	}
+
+	somsri := testIdentity{name: "somsri", mspid: "Org1MSP", attrs: map[string]string{cardIDAttr: "1234567890121"}}
+	res := Customer{}
+	json.Unmarshal(s.must(somsri, "readcustomer", "1234567890121"), &res)
+	if res.Name != "Somsri" || res.Age != 41 || res.Creator != "agent1" {
+		t.Errorf("readcustomer = %+v", res)
+	}
+	if got := s.consent("OLD1", sampleBroker).Status; got != statusAllowed {
+		t.Errorf("consent OLD1/7 = %s, want allowed", got)
+	}
+
+	s.transient = map[string][]byte{saltField: []byte("salt")}
+	json.Unmarshal(s.must(admin, "migratecustomers", "Org1MSP"), &migration)
+	if migration.Customers != 0 || migration.Deleted != 0 || len(migration.Unlinked) != 0 {
+		t.Errorf("second migratecustomers = %+v, want nothing", migration)
+	}
+}
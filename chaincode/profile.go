@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+)
+
+// dateLayout is how dates of birth and id document dates are written
+const dateLayout = "2006-01-02"
+
+// ID document types a customer's card id can come from
+const (
+	idTypeNationalID = "nationalid" //Thai national id card
+	idTypePassport   = "passport"
+)
+
+// Address is a customer's address, split the way Thai addresses are written
+type Address struct {
+	HouseNo     string `json:"houseno"`
+	Village     string `json:"village"` //moo or building
+	Street      string `json:"street"`
+	SubDistrict string `json:"subdistrict"` //tambon or khwaeng
+	District    string `json:"district"`    //amphoe or khet
+	Province    string `json:"province"`
+	PostalCode  string `json:"postalcode"`
+	Country     string `json:"country"` //ISO 3166-1 alpha-2
+}
+
+// ageOn - whole years between a date of birth and a day
+func ageOn(dob, day time.Time) int {
+	age := day.Year() - dob.Year()
+	if day.Month() < dob.Month() || (day.Month() == dob.Month() && day.Day() < dob.Day()) {
+		age-- //no birthday yet this year
+	}
+	return age
+}
+
+// ============================================================================================================================
+// withAge - fill in a customer's age as of the transaction, records made before dates of birth keep the age they stored
+// ============================================================================================================================
+func withAge(res Customer, now time.Time) Customer {
+	dob, err := time.Parse(dateLayout, res.DOB)
+	if err == nil {
+		res.Age = ageOn(dob, now)
+	}
+	return res
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// RequestKey key of an access request
+var RequestKey = "req_"
+
+// guaranteeRequestIndex lists the access requests made against a guarantee id, guarantee id then request id
+const guaranteeRequestIndex = "guarantee~request"
+
+// AccessRequest is one broker asking one customer for access, the customer allows or rejects it by its RequestID.
+// Status starts as statusPending and moves to statusAllowed or statusRejected. An allowed request moves on to
+// statusRevoked when the customer cancels the grant, and reads back as statusExpired while the grant has lapsed
+type AccessRequest struct {
+	RequestID   string   `json:"requestid"` //the id of the transaction that made it
+	GuaranteeID string   `json:"guaranteeid"`
+	BrokerNo    int      `json:"brokerno"`
+	Purpose     string   `json:"purpose"`
+	Message     string   `json:"message,omitempty"`
+	Fields      []string `json:"fields"` //Customer fields asked for
+	CreatedAt   string   `json:"createdat"`
+	Status      string   `json:"status"`
+}
+
+// ============================================================================================================================
+// getRequest - read an access request by its id, an allowed request whose grant has lapsed comes back as statusExpired
+// ============================================================================================================================
+func getRequest(stub shim.ChaincodeStubInterface, requestID string) (AccessRequest, error) {
+	req := AccessRequest{}
+	reqAsBytes, err := stub.GetState(RequestKey + requestID)
+	if err != nil {
+		return req, ledgerError(err, "Failed to get state for request "+requestID)
+	}
+	if len(reqAsBytes) == 0 {
+		return req, notFound("requestid", "This request does not exist")
+	}
+	json.Unmarshal(reqAsBytes, &req)
+
+	//expiry is never stored, the consent the request was granted on says whether it has lapsed
+	if req.Status == statusAllowed {
+		consent, err := getConsent(stub, req.GuaranteeID, req.BrokerNo)
+		if err != nil {
+			return req, err
+		}
+		if consent.RequestID == req.RequestID && consent.Status == statusExpired {
+			req.Status = statusExpired
+		}
+	}
+	return req, nil
+}
+
+// ============================================================================================================================
+// putRequest - write an access request and its guarantee id index entry
+// ============================================================================================================================
+func putRequest(stub shim.ChaincodeStubInterface, req AccessRequest) error {
+	indexKey, err := stub.CreateCompositeKey(guaranteeRequestIndex, []string{req.GuaranteeID, req.RequestID})
+	if err != nil {
+		return ledgerError(err, "Failed to create request index key")
+	}
+
+	jsonAsBytes, _ := json.Marshal(req)
+	err = stub.PutState(RequestKey+req.RequestID, jsonAsBytes)
+	if err != nil {
+		return ledgerError(err, "Failed to put request")
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return ledgerError(err, "Failed to put request index")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// pendingRequest - a request the customer can still answer, with the guarantee id and the consent it is about
+// ============================================================================================================================
+func pendingRequest(stub shim.ChaincodeStubInterface, requestID string) (AccessRequest, GuaranteeID, Consent, error) {
+	consent := Consent{}
+	req, err := getRequest(stub, requestID)
+	if err != nil {
+		return req, GuaranteeID{}, consent, err
+	}
+	gua, _, err := checkConsentParties(stub, req.GuaranteeID, req.BrokerNo)
+	if err != nil {
+		return req, gua, consent, err
+	}
+	if req.Status != statusPending {
+		return req, gua, consent, notFound("requestid", "This request has already been "+req.Status)
+	}
+	consent, err = getConsent(stub, req.GuaranteeID, req.BrokerNo)
+	return req, gua, consent, err
+}
+
+// ============================================================================================================================
+// requestsByGuarantee - every access request made against a guarantee id, by partial key range scan over the index
+// ============================================================================================================================
+func requestsByGuarantee(stub shim.ChaincodeStubInterface, gid string) ([]AccessRequest, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(guaranteeRequestIndex, []string{gid})
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get requests for "+gid)
+	}
+	defer resultsIterator.Close()
+
+	requests := []AccessRequest{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, ledgerError(err, "Failed to iterate requests for "+gid)
+		}
+		_, keyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, ledgerError(err, "Failed to split request index key")
+		}
+		req, err := getRequest(stub, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// ============================================================================================================================
+// delRequests - remove every access request made against a guarantee id, and their index entries
+// ============================================================================================================================
+func delRequests(stub shim.ChaincodeStubInterface, gid string) error {
+	requests, err := requestsByGuarantee(stub, gid)
+	if err != nil {
+		return err
+	}
+	for _, req := range requests {
+		indexKey, err := stub.CreateCompositeKey(guaranteeRequestIndex, []string{gid, req.RequestID})
+		if err != nil {
+			return ledgerError(err, "Failed to create request index key")
+		}
+		err = stub.DelState(RequestKey + req.RequestID)
+		if err != nil {
+			return ledgerError(err, "Failed to delete request")
+		}
+		err = stub.DelState(indexKey)
+		if err != nil {
+			return ledgerError(err, "Failed to delete request index")
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// Migrate Requests - give every consent left pending from before access requests a request of its own, so the customer
+// can answer it. Only an admin can do it, once after the upgrade, and running it again finds nothing left to do
+// ============================================================================================================================
+func (t *SimpleChaincode) migraterequests(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	admin, err := isAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return nil, permissionDenied("", "Only an admin can migrate requests")
+	}
+	createdAt, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(consentObjectType, []string{})
+	if err != nil {
+		return nil, ledgerError(err, "Failed to get consents")
+	}
+	defer resultsIterator.Close()
+
+	requests := []AccessRequest{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, ledgerError(err, "Failed to iterate consents")
+		}
+		consent := Consent{}
+		json.Unmarshal(kv.Value, &consent)
+		if consent.Status != statusPending || len(consent.RequestID) > 0 {
+			continue
+		}
+
+		req := AccessRequest{}
+		req.RequestID = stub.GetTxID() + "-" + strconv.Itoa(len(requests)) //one transaction makes them all
+		req.GuaranteeID = consent.GuaranteeID
+		req.BrokerNo = consent.BrokerNo
+		req.Fields = consent.Requested
+		if len(req.Fields) == 0 {
+			req.Fields = scopeFields //consents from before scopes asked for everything
+		}
+		req.CreatedAt = createdAt
+		req.Status = statusPending
+		err = putRequest(stub, req)
+		if err != nil {
+			return nil, err
+		}
+
+		//the status stays pending, so no consent event
+		consent.RequestID = req.RequestID
+		err = putConsent(stub, consent)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+
+	jsonAsBytes, _ := json.Marshal(requests)
+	return jsonAsBytes, nil
+}
+
+// ============================================================================================================================
+// Read Requests - every access request made against the customer's guarantee id, only the customer can see them
+// ============================================================================================================================
+func (t *SimpleChaincode) readrequests(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	//      0
+	// "guaranteeid"
+	gua, err := getGuarantee(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = assertCustomer(stub, gua)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := requestsByGuarantee(stub, gua.GuaranteeID)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonAsBytes, _ := json.Marshal(requests)
+	return jsonAsBytes, nil
+}
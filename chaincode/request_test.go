@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// request - a broker's request as the customer reads it back
+func (s *testStub) request(requestID string) AccessRequest {
+	s.t.Helper()
+	var requests []AccessRequest
+	json.Unmarshal(s.must(customer, "readrequests", s.consentGID(requestID)), &requests)
+	for _, req := range requests {
+		if req.RequestID == requestID {
+			return req
+		}
+	}
+	s.t.Fatalf("request %s not found", requestID)
+	return AccessRequest{}
+}
+
+// consentGID - the guarantee id a request was made against, from its stored record
+func (s *testStub) consentGID(requestID string) string {
+	req := AccessRequest{}
+	json.Unmarshal(s.State[RequestKey+requestID], &req)
+	return req.GuaranteeID
+}
+
+// ask - broker asks the customer for the given fields and gets back the request id
+func (s *testStub) ask(gid, fields string) string {
+	s.t.Helper()
+	req := AccessRequest{}
+	json.Unmarshal(s.must(broker, "requestPermission", gid, sampleBroker, fields, "account opening"), &req)
+	return req.RequestID
+}
+
+func TestRequestLifecycle(t *testing.T) {
+	s, gid := newWorld(t)
+	first := s.ask(gid, "name,telno")
+	if got := s.request(first); got.Status != statusPending || got.Purpose != "account opening" {
+		t.Errorf("new request = %+v", got)
+	}
+	s.fails(codeAlreadyExists, broker, "requestPermission", gid, sampleBroker, "", "again")
+
+	s.must(customer, "rejectBroker", first, "not now")
+	s.fails(codeNotFound, customer, "customerallow", first) //already answered
+	second := s.ask(gid, "name")
+	s.must(customer, "customerallow", second, "1")
+	s.fails(codeAlreadyExists, broker, "requestPermission", gid, sampleBroker, "", "again")
+
+	s.now = s.now.AddDate(0, 0, 2)
+	if got := s.request(second).Status; got != statusExpired {
+		t.Errorf("lapsed request = %s, want expired", got)
+	}
+	third := s.ask(gid, "name")
+	if got := s.request(second).Status; got != statusExpired {
+		t.Errorf("lapsed request after a new one = %s, want expired", got)
+	}
+	s.must(customer, "customerallow", third)
+	s.must(customer, "cancelAllow", gid, sampleBroker)
+	fourth := s.ask(gid, "name")
+
+	want := map[string]string{first: statusRejected, second: statusExpired, third: statusRevoked, fourth: statusPending}
+	for requestID, status := range want {
+		if got := s.request(requestID).Status; got != status {
+			t.Errorf("request %s = %s, want %s", requestID, got, status)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// scopeFields are the Customer fields a broker can be granted, by their json names
+var scopeFields = []string{"name", "cardid", "telno", "dob", "age", "nationality", "occupation", "address", "idtype", "idissuedate", "idexpirydate"}
+
+// ============================================================================================================================
+// parseScope - a comma separated field list in scopeFields order without repeats, empty asks for every field
+// ============================================================================================================================
+func parseScope(arg string) []string {
+	if len(strings.TrimSpace(arg)) == 0 {
+		return append([]string{}, scopeFields...)
+	}
+	asked := map[string]bool{}
+	for _, field := range strings.Split(arg, ",") {
+		asked[strings.ToLower(strings.TrimSpace(field))] = true
+	}
+	scope := []string{}
+	for _, field := range scopeFields {
+		if asked[field] {
+			scope = append(scope, field)
+		}
+	}
+	return scope
+}
+
+// ============================================================================================================================
+// validScope - argument validation for a field list, every entry has to be one of scopeFields
+// ============================================================================================================================
+func validScope(arg string) error {
+	for _, field := range strings.Split(arg, ",") {
+		if !inScope(scopeFields, strings.ToLower(strings.TrimSpace(field))) {
+			return errors.New("has an unknown field \"" + field + "\", expecting any of " + strings.Join(scopeFields, ","))
+		}
+	}
+	return nil
+}
+
+// inScope - whether a field is in a scope
+func inScope(scope []string, field string) bool {
+	for _, f := range scope {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// scopedCustomer - the customer as json with only the fields in scope
+// ============================================================================================================================
+func scopedCustomer(customer Customer, scope []string) []byte {
+	customerAsBytes, _ := json.Marshal(customer)
+	fields := map[string]json.RawMessage{}
+	json.Unmarshal(customerAsBytes, &fields)
+	for field := range fields {
+		if !inScope(scope, field) {
+			delete(fields, field)
+		}
+	}
+	jsonAsBytes, _ := json.Marshal(fields)
+	return jsonAsBytes
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want []string
+	}{
+		{"", scopeFields},
+		{"  ", scopeFields},
+		{"name", []string{"name"}},
+		{"telno, Name,cardid,name", []string{"name", "cardid", "telno"}}, //scopeFields order, no repeats
+		{"address,dob", []string{"dob", "address"}},
+	}
+	for _, tt := range tests {
+		if got := parseScope(tt.arg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseScope(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	tests := []struct {
+		arg string
+		ok  bool
+	}{
+		{"name", true},
+		{"name, CardID ,telno", true},
+		{"name,salary", false},
+		{"name,", false},
+	}
+	for _, tt := range tests {
+		if err := validScope(tt.arg); (err == nil) != tt.ok {
+			t.Errorf("validScope(%q) = %v, want ok %v", tt.arg, err, tt.ok)
+		}
+	}
+}
+
+func TestScopedCustomer(t *testing.T) {
+	res := Customer{Name: "Somchai", CardID: "1101700203450", TelNo: "0812345678"}
+	got := string(scopedCustomer(res, []string{"name", "telno"}))
+	if want := `{"name":"Somchai","telno":"0812345678"}`; got != want {
+		t.Errorf("scopedCustomer = %s, want %s", got, want)
+	}
+}
+
+func TestGrantScope(t *testing.T) {
+	s, gid := newWorld(t)
+	s.fails(codeInvalidArgument, broker, "requestPermission", gid, sampleBroker, "name,salary", "account opening")
+	requestID := s.ask(gid, "dob, name,telno")
+
+	s.fails(codeInvalidArgument, customer, "customerallow", requestID, "", "name,address") //not asked for
+	s.must(customer, "customerallow", requestID, "", "telno,name")
+	got := s.consent(gid, sampleBroker)
+	if want := []string{"name", "telno", "dob"}; !reflect.DeepEqual(got.Requested, want) {
+		t.Errorf("requested = %q, want %q", got.Requested, want)
+	}
+	if want := []string{"name", "telno"}; !reflect.DeepEqual(got.Scope, want) {
+		t.Errorf("scope = %q, want %q", got.Scope, want)
+	}
+
+	s.must(customer, "cancelAllow", gid, sampleBroker)
+	s.must(customer, "customerallow", s.ask(gid, "name,telno")) //no fields grants all that was asked for
+	if got, want := s.consent(gid, sampleBroker).Scope, []string{"name", "telno"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("scope granted in full = %q, want %q", got, want)
+	}
+}
+
+func TestReadCustomerBroker(t *testing.T) {
+	s, gid := newWorld(t)
+	s.must(stranger, "newbroke", "Broker Eight", "8")
+	s.must(customer, "customerallow", s.ask(gid, "name,telno"), "", "telno")
+
+	var got map[string]interface{}
+	json.Unmarshal(s.must(broker, "readcustomerbroker", gid, sampleBroker), &got)
+	if len(got) != 1 || got["telno"] != "0812345678" {
+		t.Errorf("readcustomerbroker = %v, want the telno only", got)
+	}
+	s.fails(codePermissionDenied, stranger, "readcustomerbroker", gid, sampleBroker) //not broker 7
+	s.fails(codePermissionDenied, stranger, "readcustomerbroker", gid, "8")          //no consent
+	s.fails(codePermissionDenied, agent, "readcustomerbroker", gid, sampleBroker)
+
+	//allowed before scopes, the grant covered everything
+	key, _ := s.CreateCompositeKey(consentObjectType, []string{gid, "8"})
+	s.seed(key, `{"guaranteeid":"`+gid+`","brokerno":8,"status":"allowed"}`)
+	got = nil
+	json.Unmarshal(s.must(stranger, "readcustomerbroker", gid, "8"), &got)
+	if got["name"] != sampleName || got["cardid"] != sampleCardID || got["age"] != float64(30) {
+		t.Errorf("readcustomerbroker without a scope = %v", got)
+	}
+
+	s.must(customer, "cancelAllow", gid, sampleBroker)
+	s.fails(codePermissionDenied, broker, "readcustomerbroker", gid, sampleBroker)
+}
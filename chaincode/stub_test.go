@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// attrOID is the certificate extension Fabric CA puts identity attributes in
+var attrOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// testIdentity is who signs a test transaction, attrs end up in the certificate the way Fabric CA puts them there
+type testIdentity struct {
+	name  string
+	mspid string
+	attrs map[string]string
+}
+
+// creator - the serialized identity the peer would hand the chaincode, with a freshly signed certificate
+func (id testIdentity) creator(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: id.name, Organization: []string{id.mspid}},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if len(id.attrs) > 0 {
+		attrsAsBytes, _ := json.Marshal(map[string]map[string]string{"attrs": id.attrs})
+		template.ExtraExtensions = []pkix.Extension{{Id: attrOID, Value: attrsAsBytes}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certAsPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: id.mspid, IdBytes: certAsPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
+
+// testStub is the pinned shimtest.MockStub with what it leaves out filled in: the transient map, private deletes and
+// scans, paged scans, key history and a clock the test moves. Like a peer, private writes only land once the
+// transaction succeeds, and a failed transaction leaves the world state as it found it
+type testStub struct {
+	*shimtest.MockStub
+	t         *testing.T
+	cc        *SimpleChaincode
+	now       time.Time
+	transient map[string][]byte
+	event     *pb.ChaincodeEvent //the last transaction's event, a transaction only carries one
+
+	txn       int
+	pvtWrites map[string]map[string][]byte //this transaction's private writes, a nil value deletes
+	writes    []*queryresult.KeyModification
+	writeKeys []string
+	history   map[string][]*queryresult.KeyModification
+}
+
+func newTestStub(t *testing.T) *testStub {
+	cc := new(SimpleChaincode)
+	return &testStub{
+		MockStub: shimtest.NewMockStub("kyc", cc),
+		t:        t,
+		cc:       cc,
+		now:      time.Date(2020, 6, 1, 9, 0, 0, 0, time.UTC),
+		history:  map[string][]*queryresult.KeyModification{},
+	}
+}
+
+// invoke - run one transaction as caller, with the transient map set beforehand
+func (s *testStub) invoke(caller testIdentity, name string, args ...string) pb.Response {
+	s.txn++
+	txid := fmt.Sprintf("tx%d", s.txn)
+	s.MockTransactionStart(txid)
+	s.TxTimestamp, _ = ptypes.TimestampProto(s.now)
+	s.Creator = caller.creator(s.t)
+	s.event = nil
+	s.pvtWrites = map[string]map[string][]byte{}
+	s.writes, s.writeKeys = nil, nil
+	saved := map[string][]byte{}
+	for key, value := range s.State {
+		saved[key] = value
+	}
+
+	var res pb.Response
+	if name == "init" {
+		res = initFunction.run(s.cc, s, name, args)
+	} else {
+		res = s.cc.call(s, name, args)
+	}
+
+	if res.Status == shim.OK {
+		for collection, writes := range s.pvtWrites {
+			if s.PvtState[collection] == nil {
+				s.PvtState[collection] = map[string][]byte{}
+			}
+			for key, value := range writes {
+				if value == nil {
+					delete(s.PvtState[collection], key)
+				} else {
+					s.PvtState[collection][key] = value
+				}
+			}
+		}
+		for i, key := range s.writeKeys {
+			s.history[key] = append(s.history[key], s.writes[i])
+		}
+	} else {
+		s.State = saved
+		s.Keys.Init()
+		for _, key := range sortedKeys(saved) {
+			s.Keys.PushBack(key)
+		}
+		s.event = nil
+	}
+	s.MockTransactionEnd(txid)
+	s.transient = nil
+	return res
+}
+
+// must - invoke and fail the test unless it succeeds
+func (s *testStub) must(caller testIdentity, name string, args ...string) []byte {
+	s.t.Helper()
+	res := s.invoke(caller, name, args...)
+	if res.Status != shim.OK {
+		s.t.Fatalf("%s(%s) failed: %s", name, strings.Join(args, ", "), res.Message)
+	}
+	return res.Payload
+}
+
+// fails - invoke and fail the test unless it fails with the given error code
+func (s *testStub) fails(code string, caller testIdentity, name string, args ...string) {
+	s.t.Helper()
+	res := s.invoke(caller, name, args...)
+	if got := responseCode(res); got != code {
+		s.t.Fatalf("%s(%s) = %s, want %s", name, strings.Join(args, ", "), res.Message, code)
+	}
+}
+
+// responseCode - the error code in a response's envelope, empty for a success
+func responseCode(res pb.Response) string {
+	if res.Status == shim.OK {
+		return ""
+	}
+	kerr := kycError{}
+	json.Unmarshal([]byte(res.Message), &kerr)
+	return kerr.Code
+}
+
+// consentEvent - the consent event the last transaction set, fails the test if there was none
+func (s *testStub) consentEvent() ConsentEvent {
+	s.t.Helper()
+	if s.event == nil || s.event.EventName != consentEventName {
+		s.t.Fatal("no consent event was set")
+	}
+	event := ConsentEvent{}
+	json.Unmarshal(s.event.Payload, &event)
+	return event
+}
+
+func (s *testStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *testStub) SetEvent(name string, payload []byte) error {
+	s.event = &pb.ChaincodeEvent{EventName: name, Payload: payload}
+	return nil
+}
+
+func (s *testStub) PutState(key string, value []byte) error {
+	s.record(key, value, false)
+	return s.MockStub.PutState(key, value)
+}
+
+func (s *testStub) DelState(key string) error {
+	s.record(key, nil, true)
+	return s.MockStub.DelState(key)
+}
+
+// record - keep a write for the key's history, it is only added once the transaction succeeds
+func (s *testStub) record(key string, value []byte, isDelete bool) {
+	ts, _ := ptypes.TimestampProto(s.now)
+	s.writes = append(s.writes, &queryresult.KeyModification{TxId: s.TxID, Value: value, Timestamp: ts, IsDelete: isDelete})
+	s.writeKeys = append(s.writeKeys, key)
+}
+
+func (s *testStub) PutPrivateData(collection, key string, value []byte) error {
+	if len(value) == 0 {
+		return fmt.Errorf("private data value for %s is empty", key)
+	}
+	return s.writePrivate(collection, key, value)
+}
+
+func (s *testStub) DelPrivateData(collection, key string) error {
+	return s.writePrivate(collection, key, nil)
+}
+
+func (s *testStub) writePrivate(collection, key string, value []byte) error {
+	if s.pvtWrites[collection] == nil {
+		s.pvtWrites[collection] = map[string][]byte{}
+	}
+	s.pvtWrites[collection][key] = value
+	return nil
+}
+
+func (s *testStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	iter := &kvIterator{}
+	for _, key := range sortedKeys(s.PvtState[collection]) {
+		if key >= startKey && key < endKey {
+			iter.kvs = append(iter.kvs, &queryresult.KV{Key: key, Value: s.PvtState[collection][key]})
+		}
+	}
+	return iter, nil
+}
+
+func (s *testStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	partialKey, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetPrivateDataByRange(collection, partialKey, partialKey+string(utf8MaxRune))
+}
+
+func (s *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	resultsIterator, err := s.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	iter := &kvIterator{}
+	metadata := &pb.QueryResponseMetadata{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if int32(len(iter.kvs)) == pageSize {
+			metadata.Bookmark = kv.Key //the next page starts here
+			break
+		}
+		iter.kvs = append(iter.kvs, kv)
+	}
+	metadata.FetchedRecordsCount = int32(len(iter.kvs))
+	return iter, metadata, nil
+}
+
+func (s *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{mods: s.history[key]}, nil
+}
+
+// utf8MaxRune ends a partial composite key range, as the shim does
+const utf8MaxRune = '\U0010FFFF'
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// kvIterator walks a fixed list of key values
+type kvIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *kvIterator) HasNext() bool { return len(it.kvs) > 0 }
+func (it *kvIterator) Close() error  { return nil }
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+// historyIterator walks a fixed list of key modifications
+type historyIterator struct {
+	mods []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool { return len(it.mods) > 0 }
+func (it *historyIterator) Close() error  { return nil }
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	mod := it.mods[0]
+	it.mods = it.mods[1:]
+	return mod, nil
+}
+
+// the identities the tests act as, the admin's MSP is the one Init lists
+var (
+	admin    = testIdentity{name: "admin", mspid: "AdminMSP", attrs: map[string]string{roleAttr: adminRole}}
+	agent    = testIdentity{name: "agent", mspid: "Org1MSP"}
+	customer = testIdentity{name: "somchai", mspid: "Org1MSP", attrs: map[string]string{cardIDAttr: sampleCardID}}
+	broker   = testIdentity{name: "broker", mspid: "Org2MSP"}
+	stranger = testIdentity{name: "stranger", mspid: "Org2MSP"}
+)
+
+// the customer and broker newWorld makes
+const (
+	sampleCardID = "1101700203450"
+	sampleName   = "Somchai"
+	sampleBroker = "7"
+)
+
+// newWorld - a stub after Init, with one customer made by agent and one broker registered by broker. It returns the
+// customer's guarantee id
+func newWorld(t *testing.T) (*testStub, string) {
+	s := newTestStub(t)
+	s.transient = map[string][]byte{cardIDSecretField: []byte("cardid secret")}
+	s.must(admin, "init", "99", admin.mspid)
+	gid := s.newCustomer(sampleCardID, sampleName)
+	s.must(broker, "newbroke", "Broker Seven", sampleBroker)
+	return s, gid
+}
+
+// customerInput - a customer that passes every check in transientCustomer
+func sampleInput(cardid, name string) customerInput {
+	return customerInput{
+		Name:         name,
+		CardID:       cardid,
+		TelNo:        "0812345678",
+		DOB:          "1990-04-13",
+		Nationality:  "TH",
+		Occupation:   "engineer",
+		IDType:       idTypeNationalID,
+		IDIssueDate:  "2020-01-01",
+		IDExpiryDate: "2028-01-01",
+	}
+}
+
+// withCustomer - put a customer in the transient map, the way newcustomer and update_customer take it
+func (s *testStub) withCustomer(input customerInput) {
+	inputAsBytes, _ := json.Marshal(input)
+	s.transient = map[string][]byte{
+		saltField:      []byte("salt"),
+		gidSecretField: []byte("gid secret"),
+		customerField:  inputAsBytes,
+	}
+}
+
+// newCustomer - make a customer as agent and return its guarantee id
+func (s *testStub) newCustomer(cardid, name string) string {
+	s.t.Helper()
+	s.withCustomer(sampleInput(cardid, name))
+	s.must(agent, "newcustomer")
+	link, err := getCusGuaID(s, cardid)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return link.GuaranteeID
+}
+
+// seed - write a key straight to the world state, the way an older version of the chaincode left it
+func (s *testStub) seed(key, value string) {
+	s.MockTransactionStart("seed")
+	s.MockStub.PutState(key, []byte(value))
+	s.MockTransactionEnd("seed")
+}
+
+// consent - the consent between a guarantee id and a broker as it is stored
+func (s *testStub) consent(gid, brokeNo string) Consent {
+	s.t.Helper()
+	key, _ := s.CreateCompositeKey(consentObjectType, []string{gid, brokeNo})
+	consent := Consent{Status: statusNone}
+	json.Unmarshal(s.State[key], &consent)
+	return consent
+}
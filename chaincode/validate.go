@@ -0,0 +1,112 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// the age range a customer has to fall in
+const (
+	minAge = 18
+	maxAge = 120
+)
+
+var (
+	nationalIDFormat = regexp.MustCompile(`^[0-9]{13}$`)
+	passportFormat   = regexp.MustCompile(`^[A-Z0-9]{6,9}$`) //ICAO 9303, up to nine letters and digits
+	thaiPhoneFormat  = regexp.MustCompile(`^0[2-9][0-9]{7,8}$`)
+	e164PhoneFormat  = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	countryFormat    = regexp.MustCompile(`^[A-Z]{2}$`) //ISO 3166-1 alpha-2
+)
+
+// ============================================================================================================================
+// validCardID - the card id has to be a well formed number for the id document type it comes from
+// ============================================================================================================================
+func validCardID(idType, cardid string) error {
+	switch idType {
+	case idTypeNationalID:
+		if !nationalIDFormat.MatchString(cardid) || !nationalIDChecksum(cardid) {
+			return invalidField(codeInvalidNationalID, "cardid", "A Thai national id is 13 digits and the last is a check digit")
+		}
+	case idTypePassport:
+		if !passportFormat.MatchString(cardid) {
+			return invalidField(codeInvalidPassport, "cardid", "A passport number is 6 to 9 upper case letters and digits")
+		}
+	default:
+		return invalidArgument("idtype", "The id type has to be "+idTypeNationalID+" or "+idTypePassport)
+	}
+	return nil
+}
+
+// nationalIDChecksum - the 13th digit of a Thai national id is a mod 11 check digit over the first 12
+func nationalIDChecksum(cardid string) bool {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		sum += int(cardid[i]-'0') * (13 - i)
+	}
+	return strconv.Itoa((11-sum%11)%10) == cardid[12:]
+}
+
+// ============================================================================================================================
+// validPhone - a Thai number written locally, 0 then 8 or 9 digits, or any number in E.164 form
+// ============================================================================================================================
+func validPhone(telno string) error {
+	if !thaiPhoneFormat.MatchString(telno) && !e164PhoneFormat.MatchString(telno) {
+		return invalidField(codeInvalidPhone, "telno", "A phone number is a Thai number such as 0812345678 or E.164 such as +66812345678")
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// validAge - the customer has to be between minAge and maxAge
+// ============================================================================================================================
+func validAge(age int) error {
+	if age < minAge || age > maxAge {
+		return invalidField(codeInvalidAge, "age", "The age has to be between "+strconv.Itoa(minAge)+" and "+strconv.Itoa(maxAge))
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// validDOB - a date of birth that puts the customer's age, as of now, between minAge and maxAge
+// ============================================================================================================================
+func validDOB(dob string, now time.Time) error {
+	day, err := time.Parse(dateLayout, dob)
+	if err != nil {
+		return invalidField(codeInvalidDate, "dob", "The date of birth has to be written "+dateLayout)
+	}
+	return validAge(ageOn(day, now))
+}
+
+// ============================================================================================================================
+// validIDDates - the id document has to have been issued already and not have expired
+// ============================================================================================================================
+func validIDDates(issued, expires string, now time.Time) error {
+	issueDay, err := time.Parse(dateLayout, issued)
+	if err != nil {
+		return invalidField(codeInvalidDate, "idissuedate", "The id issue date has to be written "+dateLayout)
+	}
+	expiryDay, err := time.Parse(dateLayout, expires)
+	if err != nil {
+		return invalidField(codeInvalidDate, "idexpirydate", "The id expiry date has to be written "+dateLayout)
+	}
+	if issueDay.After(now) {
+		return invalidField(codeInvalidDate, "idissuedate", "The id issue date is in the future")
+	}
+	if !expiryDay.After(issueDay) {
+		return invalidField(codeInvalidDate, "idexpirydate", "The id expiry date has to be after its issue date")
+	}
+	if expiryDay.Before(now.Truncate(24 * time.Hour)) {
+		return invalidField(codeInvalidDate, "idexpirydate", "The id document has expired")
+	}
+	return nil
+}
+
+// validCountry - a two letter upper case country code
+func validCountry(arg, country string) error {
+	if !countryFormat.MatchString(country) {
+		return invalidArgument(arg, "A country is its ISO 3166-1 alpha-2 code, such as TH")
+	}
+	return nil
+}
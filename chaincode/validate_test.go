@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// code - the error code of a kycError, empty for nil
+func code(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.(*kycError).Code
+}
+
+func TestNationalIDChecksum(t *testing.T) {
+	tests := []struct {
+		cardid string
+		want   bool
+	}{
+		{"1101700203450", true},
+		{"1234567890121", true},
+		{"1234567890123", false},
+		{"1101700203451", false},
+		{"0000000000001", true}, //sum 0, check digit (11-0)%10
+	}
+	for _, tt := range tests {
+		if got := nationalIDChecksum(tt.cardid); got != tt.want {
+			t.Errorf("nationalIDChecksum(%q) = %v, want %v", tt.cardid, got, tt.want)
+		}
+	}
+}
+
+func TestValidCardID(t *testing.T) {
+	tests := []struct {
+		idType, cardid string
+		want           string
+	}{
+		{idTypeNationalID, "1101700203450", ""},
+		{idTypeNationalID, "1101700203451", codeInvalidNationalID},
+		{idTypeNationalID, "110170020345", codeInvalidNationalID},
+		{idTypeNationalID, "AA12345678901", codeInvalidNationalID},
+		{idTypePassport, "AA1234567", ""},
+		{idTypePassport, "A12345", ""},
+		{idTypePassport, "A1234", codeInvalidPassport},
+		{idTypePassport, "AA12345678", codeInvalidPassport},
+		{idTypePassport, "aa123456", codeInvalidPassport},
+		{"licence", "1101700203450", codeInvalidArgument},
+	}
+	for _, tt := range tests {
+		if got := code(validCardID(tt.idType, tt.cardid)); got != tt.want {
+			t.Errorf("validCardID(%q, %q) = %q, want %q", tt.idType, tt.cardid, got, tt.want)
+		}
+	}
+}
+
+func TestValidPhone(t *testing.T) {
+	tests := []struct {
+		telno string
+		want  string
+	}{
+		{"0812345678", ""},
+		{"021234567", ""},
+		{"+66812345678", ""},
+		{"+1234567", ""},
+		{"0112345678", codeInvalidPhone},
+		{"08123456", codeInvalidPhone},
+		{"812345678", codeInvalidPhone},
+		{"+0812345678", codeInvalidPhone},
+		{"081-234-5678", codeInvalidPhone},
+	}
+	for _, tt := range tests {
+		if got := code(validPhone(tt.telno)); got != tt.want {
+			t.Errorf("validPhone(%q) = %q, want %q", tt.telno, got, tt.want)
+		}
+	}
+}
+
+func TestValidIDDates(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		issued, expires string
+		want            string
+	}{
+		{"2020-01-01", "2028-01-01", ""},
+		{"2020-01-01", "2024-06-01", ""}, //expires today, still valid
+		{"2020-01-01", "2024-05-31", codeInvalidDate},
+		{"2024-06-02", "2030-01-01", codeInvalidDate},
+		{"2020-01-01", "2020-01-01", codeInvalidDate},
+		{"01/01/2020", "2028-01-01", codeInvalidDate},
+		{"2020-01-01", "2028-13-01", codeInvalidDate},
+	}
+	for _, tt := range tests {
+		if got := code(validIDDates(tt.issued, tt.expires, now)); got != tt.want {
+			t.Errorf("validIDDates(%q, %q) = %q, want %q", tt.issued, tt.expires, got, tt.want)
+		}
+	}
+}
+
+func TestValidDOB(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		dob  string
+		want string
+	}{
+		{"1990-04-13", ""},
+		{"2006-06-01", ""}, //eighteen today
+		{"2006-06-02", codeInvalidAge},
+		{"1900-01-01", codeInvalidAge},
+		{"13/04/1990", codeInvalidDate},
+	}
+	for _, tt := range tests {
+		if got := code(validDOB(tt.dob, now)); got != tt.want {
+			t.Errorf("validDOB(%q) = %q, want %q", tt.dob, got, tt.want)
+		}
+	}
+}
+
+func TestCustomerHandlersValidate(t *testing.T) {
+	s, _ := newWorld(t)
+	tests := []struct {
+		change func(*customerInput)
+		code   string
+	}{
+		{func(in *customerInput) { in.CardID = "1234567890122" }, codeInvalidNationalID},
+		{func(in *customerInput) { in.TelNo = "0112345678" }, codeInvalidPhone},
+		{func(in *customerInput) { in.DOB = "2015-01-01" }, codeInvalidAge},
+		{func(in *customerInput) { in.IDType = idTypePassport }, codeInvalidPassport},
+		{func(in *customerInput) { in.Name = "" }, codeInvalidArgument},
+	}
+	for _, tt := range tests {
+		input := sampleInput("1234567890121", "Somsri")
+		tt.change(&input)
+		s.withCustomer(input)
+		s.fails(tt.code, agent, "newcustomer")
+
+		input = sampleInput(sampleCardID, sampleName)
+		tt.change(&input)
+		s.withCustomer(input)
+		s.fails(tt.code, agent, "update_customer")
+	}
+	if _, err := getCusGuaID(s, "1234567890121"); code(err) != codeNotFound {
+		t.Errorf("a rejected customer was stored, getCusGuaID = %v", err)
+	}
+	res := Customer{}
+	json.Unmarshal(s.must(customer, "readcustomer", sampleCardID), &res)
+	if res.Name != sampleName || res.TelNo != "0812345678" || res.DOB != "1990-04-13" {
+		t.Errorf("a rejected update changed the customer to %+v", res)
+	}
+}
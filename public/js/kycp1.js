@@ -59,6 +59,13 @@ $(document).on('ready', function() {
 	});
 	
 	
+	//next page of customers, the server only sends one page at a time
+	$('#moreCustomers').click(function(){
+		var bookmark = $(this).attr('bookmark');
+		$(this).hide();
+		if(bookmark) ws.send(JSON.stringify({type: 'get', v: 1, bookmark: bookmark}));
+	});
+	
 	//drag and drop marble
 	$('#customerwrap, #trashbin').sortable({connectWith: '.sortable'}).disableSelection();
 	$('#customerwrap').droppable({drop:
@@ -115,6 +122,7 @@ $(document).on('ready', function() {
 		console.log('getting new balls');
 		setTimeout(function(){
 			$('#customerwrap').html('');											//reset the panel
+			$('#moreCustomers').hide();
 			$('#brokerwrap').html('');
 			ws.send(JSON.stringify({type: 'get', v: 1}));						//need to wait a bit
 			ws.send(JSON.stringify({type: 'chainstats', v: 1}));
@@ -189,6 +197,12 @@ function connect_to_server(){
 				console.log('rec - cus', msgObj.msg, msgObj);
 				build_customer(msgObj.customer);
 			}
+			else if(msgObj.msg === 'action' && msgObj.status === 'page'){
+				$('#moreCustomers').attr('bookmark', msgObj.bookmark).show();			//there are more, fetch them when asked
+			}
+			else if(msgObj.msg === 'action' && msgObj.status === 'finished'){
+				$('#moreCustomers').removeAttr('bookmark').hide();
+			}
 			else if(msgObj.msg === 'chainstats'){
 				console.log('rec', msgObj.msg, ': ledger blockheight', msgObj.chainstats.height, 'block', msgObj.blockstats.height);
 				if(msgObj.blockstats && msgObj.blockstats.transactions) {
@@ -251,27 +265,14 @@ function build_ball(data){
 
 function build_customer(data){
 	var html = '';
-	var colorClass = '';
-	var size = 'fa-5x';
 	
 	console.log('data', data);
-	data.name = escapeHtml(data.name);
-	data.color = escapeHtml('blue');
-	//data.user = escapeHtml(data.user);
+	data.guaranteeid = escapeHtml(data.guaranteeid);						//customers are private, all we get is the guarantee id
 	
-	console.log('got a customer: ', data.color);
-	if(!$('#' + data.name).length){								//only populate if it doesn't exists
-		//if(data.size == 16) size = 'fa-3x';
-		if(data.color) colorClass = data.color.toLowerCase();
-		
-		//html += '<span id="' + data.name + '" class="fa fa-circle ' + size + ' ball ' + colorClass + ' title="' + data.name + '" user="customer">'+data.name+'</span>';
-		html += '<span style="font-size: 200%" id="' + data.name + '" class="fa fa-square fa-1x ball blue title="' + data.name +'">  '+data.name+'  </span>'
-		//if(data.user && data.user.toLowerCase() == bag.setup.USER1){
-			$('#customerwrap').append(html);
-		//}
-		// else{
-		// 	$('#user2wrap').append(html);
-		// }
+	console.log('got a customer: ', data.guaranteeid);
+	if(!$('#' + data.guaranteeid).length){								//only populate if it doesn't exists
+		html += '<span style="font-size: 200%" id="' + data.guaranteeid + '" class="fa fa-square fa-1x ball blue" title="' + data.guaranteeid + '">  ' + data.guaranteeid + '  </span>';
+		$('#customerwrap').append(html);
 	}
 	console.log('html after build - ', html);
 	return html;
@@ -4,6 +4,7 @@
 var ibc = {};
 var chaincode = {};
 var async = require('async');
+var page_size = '100';																		//customers per listCustomers call
 
 module.exports.setup = function(sdk, cc){
 	ibc = sdk;
@@ -13,30 +14,16 @@ module.exports.setup = function(sdk, cc){
 module.exports.process_msg = function(ws, data){
 	console.log('kyc part1 - process_msg');
 	if(data.v === 1){																						//only look at messages for part 1
-		if(data.type == 'create'){
-			console.log('kyc - its a create!');
-			if(data.name && data.telno && data.age && data.occupation){
-				chaincode.invoke.new_customer([data.name, data.telno, data.age, data.occupation], cb_invoked);	//create a new customer
-			}
-		}																					//only look at messages for part 1
-		if(data.type == 'createcustomer'){
-			console.log('its a createcustomer!');
-			if(data.name && data.telno && data.age && data.occupation){
-				console.log('kyc - c cus invoke');
-				chaincode.invoke.new_customer([data.name, data.telno, data.age, data.occupation], cb_invoked);	//create a new customer
-			}
+		if(data.type == 'create' || data.type == 'createcustomer' || data.type == 'update_customer'){
+			//newcustomer and update_customer take the customer from the transient map, never from the args,
+			//and this sdk can not send a transient map, so there is nothing we can invoke from here
+			console.log('kyc - ' + data.type + ' needs a transient customer entry, use a client that can send one');
+			sendMsg({msg: 'action', e: 'transient map not supported', status: 'unsupported', type: data.type});
 		}
-		if(data.type == 'update_customer'){
-			console.log('its update customer');
-			if(data.name && data.telno && data.age && data.occupation){
-				console.log('kyc update customer');
-				chaincode.invoke.update_customer([data.name,date.telno, data.age , data.occupation],cb_invoked); //update customer
-			}
-		}
-        
 		else if(data.type == 'get'){
 			console.log('get customers msg');
-			chaincode.query.read(['_customerindex'], cb_got_index);
+			if(data.bookmark) chaincode.query.listCustomers([page_size, data.bookmark], cb_got_index);	//the page after the one the ui has
+			else chaincode.query.listCustomers([page_size], cb_got_index);
 		}
         /*
 		else if(data.type == 'transfer'){
@@ -58,30 +45,19 @@ module.exports.process_msg = function(ws, data){
 		}
 	}
 
-	//got the customer index, lets get each customer
-	function cb_got_index(e, index){
-		console.log('index', index);
+	//got a page of the customer index, send each customer on, the ui asks for the next page with the bookmark
+	function cb_got_index(e, page){
+		console.log('page', page);
 		if(e != null) console.log('[ws error] did not get customer index:', e);
 		else{
 			try{
-				var json = JSON.parse(index);
-				var keys = Object.keys(json);
-				var concurrency = 1;
-
-				//serialized version
-				async.eachLimit(keys, concurrency, function(key, cb) {
-					console.log('!', json[key]);
-					chaincode.query.read([json[key]], function(e, customer) {
-						if(e != null) console.log('[ws error] did not get customer:', e);
-						else {
-							console.log('read !!!! ', JSON.parse(customer));
-							if(customer) sendMsg({msg: 'customer', e: e, customer: JSON.parse(customer)});
-							cb(null);
-						}
-					});
-				}, function() {
-					sendMsg({msg: 'action', e: e, status: 'finished'});
+				var json = JSON.parse(page);
+				json.records.forEach(function(record){
+					//customers are private data, the public list only has their guarantee ids
+					sendMsg({msg: 'customer', e: e, customer: {guaranteeid: record.guaranteeid}});
 				});
+				if(json.bookmark) sendMsg({msg: 'action', e: e, status: 'page', bookmark: json.bookmark});
+				else sendMsg({msg: 'action', e: e, status: 'finished'});
 			}
 			catch(e){
 				console.log('[ws error] could not parse response', e);